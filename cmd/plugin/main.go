@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"strings"
 
 	"github.com/houwenchen/kubernetes-csi/pkg/config"
 	"github.com/houwenchen/kubernetes-csi/pkg/driver"
@@ -13,6 +15,22 @@ var (
 	driverName = flag.String("drivername", defaultDriverName, "name of driver")
 	nodeID     = flag.String("nodeid", "", "node id")
 	enableLVM  = flag.Bool("enablelvm", true, "choose the way to create volume")
+	mode       = flag.String("mode", "all", "services to run: controller (no lvm access, only delegates expansion to nodes), node or all")
+
+	snapshotSizePercent        = flag.Int("snapshot-size-percent", 100, "default size of COW snapshot as a percentage of origin volume size")
+	thinPoolOverprovisionRatio = flag.Float64("thinpool-overprovision-ratio", 0, "default maximum ratio of virtual size of thin volumes to thin pool size, 0 for unlimited")
+	thinPoolFillThreshold      = flag.Int("thinpool-fill-threshold", 90, "thin pool data usage percentage above which volumes in the pool are reported abnormal, 0 to disable")
+
+	topologyLabels = flag.String("topology-labels", "", "comma separated key=value topology labels reported besides node, e.g. topology.kubernetes.io/zone=zone-a")
+
+	provisionVG      = flag.String("provision-vg", "", "vg created or extended with matching devices when node starts, disabled if empty")
+	provisionDevices = flag.String("provision-devices", "", "comma separated device path globs used to provision vg, e.g. /dev/sd[b-d],/dev/nvme*n1, at least one of provision-devices and provision-filters is required with provision-vg")
+	provisionFilters = flag.String("provision-filters", "", "comma separated device filters used to provision vg, e.g. unmounted,nopartitions,type=disk,size>100Gi")
+	provisionForce   = flag.Bool("provision-force", false, "overwrite devices with existing filesystem or partition table signatures when provisioning vg")
+
+	controllerCapabilities = flag.String("controller-capabilities", "", "comma separated controller capabilities to advertise, e.g. CREATE_DELETE_VOLUME,GET_CAPACITY, detected at runtime if empty")
+	nodeCapabilities       = flag.String("node-capabilities", "", "comma separated node capabilities to advertise, e.g. STAGE_UNSTAGE_VOLUME, detected at runtime if empty")
+	pluginCapabilities     = flag.String("plugin-capabilities", "", "comma separated plugin capabilities to advertise, e.g. CONTROLLER_SERVICE,ONLINE, detected at runtime if empty")
 )
 
 var (
@@ -22,12 +40,19 @@ var (
 )
 
 func init() {
+	// 部署文件中使用 -v 设置日志级别
+	klog.InitFlags(nil)
 	flag.Set("logtostderr", "true")
 }
 
 func main() {
 	flag.Parse()
 
+	labels, err := parseLabels(*topologyLabels)
+	if err != nil {
+		klog.Fatalf("parse topology labels failed, err: %v\n", err)
+	}
+
 	cfg := &config.Config{
 		DriverName:    *driverName,
 		EndPoint:      *endpoint,
@@ -35,6 +60,21 @@ func main() {
 		VendorVersion: version,
 		VolumeDir:     defaultVolumePrefix,
 		EnableLVM:     *enableLVM,
+		Mode:          *mode,
+
+		SnapshotSizePercent:        *snapshotSizePercent,
+		ThinPoolFillThreshold:      *thinPoolFillThreshold,
+		ThinPoolOverprovisionRatio: *thinPoolOverprovisionRatio,
+		TopologyLabels:             labels,
+
+		ProvisionVGName:  *provisionVG,
+		ProvisionDevices: splitList(*provisionDevices),
+		ProvisionFilters: splitList(*provisionFilters),
+		ProvisionForce:   *provisionForce,
+
+		ControllerCapabilities: splitList(*controllerCapabilities),
+		NodeCapabilities:       splitList(*nodeCapabilities),
+		PluginCapabilities:     splitList(*pluginCapabilities),
 	}
 
 	csidriver, err := driver.NewCSIDriver(cfg)
@@ -46,3 +86,27 @@ func main() {
 		klog.Fatalf("csi driver run failed, err: %v\n", err)
 	}
 }
+
+// 解析逗号分隔的参数, 忽略空项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 解析逗号分隔的 key=value 参数
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, item := range splitList(s) {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok || len(strings.TrimSpace(key)) == 0 {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", item)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
@@ -7,6 +7,7 @@ require (
 	github.com/container-storage-interface/spec v1.8.0
 	github.com/google/uuid v1.3.0
 	google.golang.org/grpc v1.55.0
+	google.golang.org/protobuf v1.30.0
 	k8s.io/klog/v2 v2.100.1
 )
 
@@ -17,5 +18,4 @@ require (
 	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20230526203410-71b5a4ffd15e // indirect
-	google.golang.org/protobuf v1.30.0 // indirect
 )
@@ -9,4 +9,33 @@ type Config struct {
 	VolumeDir string
 
 	EnableLVM bool
+
+	// 运行模式: controller, node 或者 all, 为空时为 all
+	Mode string
+
+	// COW snapshot 默认大小占 origin 大小的百分比
+	SnapshotSizePercent int
+
+	// thin pool 数据空间使用率超过该百分比时 volume 视为异常, 小于等于 0 时不检查
+	ThinPoolFillThreshold int
+
+	// thin pool 默认的超配比例, StorageClass 中可以通过 overprovisionratio 覆盖, 小于等于 0 时不限制
+	ThinPoolOverprovisionRatio float64
+
+	// NodeGetInfo 中除 node 外额外上报的拓扑标签, 如 topology.kubernetes.io/zone
+	TopologyLabels map[string]string
+
+	// node 启动时使用匹配的设备创建或者扩容该 vg, 为空时不自动创建
+	ProvisionVGName string
+	// 参与自动创建 vg 的设备路径 glob, 如 /dev/sd[b-d], 为空时只使用过滤条件筛选, 两者不能同时为空
+	ProvisionDevices []string
+	// lsblk 设备过滤条件, 如 unmounted, nopartitions, type=disk, size>100Gi
+	ProvisionFilters []string
+	// 设备上有文件系统或者分区表等签名时是否强制覆盖
+	ProvisionForce bool
+
+	// 覆盖运行时探测到的能力集, 为空时使用探测结果, 值为 csi 中的能力名称, 如 CREATE_DELETE_VOLUME
+	ControllerCapabilities []string
+	NodeCapabilities       []string
+	PluginCapabilities     []string
 }
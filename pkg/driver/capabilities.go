@@ -0,0 +1,229 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/lvm"
+	"github.com/houwenchen/kubernetes-csi/pkg/mount"
+	"k8s.io/klog/v2"
+)
+
+// 各个 server 对外声明的能力集
+type capabilitySet struct {
+	controller      []csi.ControllerServiceCapability_RPC_Type
+	node            []csi.NodeServiceCapability_RPC_Type
+	pluginService   []csi.PluginCapability_Service_Type
+	pluginExpansion []csi.PluginCapability_VolumeExpansion_Type
+}
+
+// 只运行 controller 服务时声明的能力, 其他操作都需要访问 node 上的 lvm
+var controllerOnlyCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+	csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+}
+
+func defaultCapabilitySet() *capabilitySet {
+	return &capabilitySet{
+		controller:      defaultControllerServiceCapability_RPC_Types,
+		node:            defaultNodeServiceCapability_RPC_Types,
+		pluginService:   defaultPluginCapability_Service_Types,
+		pluginExpansion: defaultPluginCapability_VolumeExpansion_Types,
+	}
+}
+
+// 根据运行模式和节点上实际支持的功能裁剪默认能力集
+func (d *CSIDriver) detectCapabilities() *capabilitySet {
+	caps := defaultCapabilitySet()
+
+	// 没有运行的服务不声明对应的能力
+	if !d.runController() {
+		caps.controller = nil
+		caps.pluginService = removeCapabilities(caps.pluginService, csi.PluginCapability_Service_CONTROLLER_SERVICE)
+	}
+	if !d.runNode() {
+		caps.node = nil
+	}
+
+	// 只运行 controller 服务时不能执行 lvm 命令, 只声明交给 node 完成的扩容能力
+	if d.runController() && !d.runNode() {
+		caps.controller = controllerOnlyCapabilities
+	}
+
+	if d.runController() && d.runNode() {
+		supported, err := lvm.SnapshotSupported()
+		if err != nil {
+			klog.Errorf("detect snapshot support failed: %v", err)
+		}
+		if !supported {
+			klog.Info("neither thin pool nor dm-snapshot is available, disable snapshot capabilities")
+			caps.controller = removeCapabilities(caps.controller,
+				csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+				csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+			)
+		}
+	}
+
+	// 文件系统扩容在 node 上执行, 只运行 controller 时无法探测
+	if d.runNode() && !mount.ResizeToolsAvailable() {
+		klog.Info("filesystem resize tools are not available, disable expansion capabilities")
+		caps.controller = removeCapabilities(caps.controller, csi.ControllerServiceCapability_RPC_EXPAND_VOLUME)
+		caps.node = removeCapabilities(caps.node, csi.NodeServiceCapability_RPC_EXPAND_VOLUME)
+		caps.pluginExpansion = nil
+	}
+
+	return caps
+}
+
+// 配置中指定的能力集覆盖探测结果
+func (d *CSIDriver) resolveCapabilities() (*capabilitySet, error) {
+	caps := d.detectCapabilities()
+
+	var err error
+	if names := d.config.ControllerCapabilities; len(names) > 0 {
+		if caps.controller, err = parseControllerCapabilities(names); err != nil {
+			return nil, err
+		}
+	}
+	if names := d.config.NodeCapabilities; len(names) > 0 {
+		if caps.node, err = parseNodeCapabilities(names); err != nil {
+			return nil, err
+		}
+	}
+	if names := d.config.PluginCapabilities; len(names) > 0 {
+		if caps.pluginService, caps.pluginExpansion, err = parsePluginCapabilities(names); err != nil {
+			return nil, err
+		}
+	}
+
+	klog.Infof("controller capabilities: %v, node capabilities: %v, plugin capabilities: %v %v",
+		caps.controller, caps.node, caps.pluginService, caps.pluginExpansion)
+	return caps, nil
+}
+
+func removeCapabilities[T comparable](caps []T, removed ...T) []T {
+	var result []T
+	for _, c := range caps {
+		found := false
+		for _, r := range removed {
+			if c == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// 能力名称使用 csi 中的枚举名, 如 CREATE_DELETE_VOLUME
+func normalizeCapabilityName(name string) string {
+	return strings.ToUpper(strings.TrimSpace(name))
+}
+
+func parseControllerCapabilities(names []string) ([]csi.ControllerServiceCapability_RPC_Type, error) {
+	var types []csi.ControllerServiceCapability_RPC_Type
+	for _, name := range names {
+		v, ok := csi.ControllerServiceCapability_RPC_Type_value[normalizeCapabilityName(name)]
+		if !ok || v == 0 {
+			return nil, fmt.Errorf("unknown controller capability %s", name)
+		}
+		types = append(types, csi.ControllerServiceCapability_RPC_Type(v))
+	}
+	return types, nil
+}
+
+func parseNodeCapabilities(names []string) ([]csi.NodeServiceCapability_RPC_Type, error) {
+	var types []csi.NodeServiceCapability_RPC_Type
+	for _, name := range names {
+		v, ok := csi.NodeServiceCapability_RPC_Type_value[normalizeCapabilityName(name)]
+		if !ok || v == 0 {
+			return nil, fmt.Errorf("unknown node capability %s", name)
+		}
+		types = append(types, csi.NodeServiceCapability_RPC_Type(v))
+	}
+	return types, nil
+}
+
+// plugin 能力包括 service 类型 (如 CONTROLLER_SERVICE) 和 volume expansion 类型 (ONLINE, OFFLINE)
+func parsePluginCapabilities(names []string) ([]csi.PluginCapability_Service_Type, []csi.PluginCapability_VolumeExpansion_Type, error) {
+	var serviceTypes []csi.PluginCapability_Service_Type
+	var expansionTypes []csi.PluginCapability_VolumeExpansion_Type
+	for _, name := range names {
+		name = normalizeCapabilityName(name)
+		if v, ok := csi.PluginCapability_Service_Type_value[name]; ok && v != 0 {
+			serviceTypes = append(serviceTypes, csi.PluginCapability_Service_Type(v))
+			continue
+		}
+		if v, ok := csi.PluginCapability_VolumeExpansion_Type_value[name]; ok && v != 0 {
+			expansionTypes = append(expansionTypes, csi.PluginCapability_VolumeExpansion_Type(v))
+			continue
+		}
+		return nil, nil, fmt.Errorf("unknown plugin capability %s", name)
+	}
+	return serviceTypes, expansionTypes, nil
+}
+
+func newControllerServiceCapabilities(types []csi.ControllerServiceCapability_RPC_Type) []*csi.ControllerServiceCapability {
+	capabilities := make([]*csi.ControllerServiceCapability, 0)
+
+	for _, RPCType := range types {
+		cap := &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: RPCType,
+				},
+			}}
+
+		capabilities = append(capabilities, cap)
+	}
+
+	return capabilities
+}
+
+func newNodeServiceCapabilities(types []csi.NodeServiceCapability_RPC_Type) []*csi.NodeServiceCapability {
+	capabilities := make([]*csi.NodeServiceCapability, 0)
+
+	for _, RPCType := range types {
+		cap := &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: RPCType,
+				},
+			}}
+
+		capabilities = append(capabilities, cap)
+	}
+
+	return capabilities
+}
+
+func newPluginCapabilities(serviceTypes []csi.PluginCapability_Service_Type, expansionTypes []csi.PluginCapability_VolumeExpansion_Type) []*csi.PluginCapability {
+	capabilities := make([]*csi.PluginCapability, 0)
+
+	for _, svcType := range serviceTypes {
+		cap := &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: svcType,
+				},
+			}}
+
+		capabilities = append(capabilities, cap)
+	}
+
+	for _, expansionType := range expansionTypes {
+		cap := &csi.PluginCapability{
+			Type: &csi.PluginCapability_VolumeExpansion_{
+				VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+					Type: expansionType,
+				},
+			}}
+
+		capabilities = append(capabilities, cap)
+	}
+
+	return capabilities
+}
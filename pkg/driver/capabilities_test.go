@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/config"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	controller, err := parseControllerCapabilities([]string{"CREATE_DELETE_VOLUME", " get_capacity "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(controller) != 2 || controller[1] != csi.ControllerServiceCapability_RPC_GET_CAPACITY {
+		t.Errorf("unexpected controller capabilities: %v", controller)
+	}
+
+	services, expansions, err := parsePluginCapabilities([]string{"CONTROLLER_SERVICE", "ONLINE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || len(expansions) != 1 || expansions[0] != csi.PluginCapability_VolumeExpansion_ONLINE {
+		t.Errorf("unexpected plugin capabilities: %v %v", services, expansions)
+	}
+
+	for _, names := range [][]string{{"NOT_A_CAPABILITY"}, {"UNKNOWN"}} {
+		if _, err := parseControllerCapabilities(names); err == nil {
+			t.Errorf("expected error for controller capabilities %v", names)
+		}
+		if _, err := parseNodeCapabilities(names); err == nil {
+			t.Errorf("expected error for node capabilities %v", names)
+		}
+		if _, _, err := parsePluginCapabilities(names); err == nil {
+			t.Errorf("expected error for plugin capabilities %v", names)
+		}
+	}
+}
+
+func TestRemoveCapabilities(t *testing.T) {
+	caps := removeCapabilities(defaultControllerServiceCapability_RPC_Types,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+	)
+	if len(caps) != len(defaultControllerServiceCapability_RPC_Types)-2 {
+		t.Errorf("unexpected capabilities: %v", caps)
+	}
+	for _, c := range caps {
+		if c == csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT || c == csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS {
+			t.Errorf("capability %v should be removed", c)
+		}
+	}
+}
+
+func TestDetectCapabilitiesByMode(t *testing.T) {
+	for _, mode := range []string{ModeController, ModeNode, ModeAll} {
+		d, err := NewCSIDriver(&config.Config{
+			DriverName: "csidriver.whou.io",
+			EndPoint:   "unix:///csi/csi.sock",
+			NodeID:     "node-1",
+			Mode:       mode,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		caps := d.detectCapabilities()
+		if got := len(caps.controller) > 0; got != d.runController() {
+			t.Errorf("mode %s: unexpected controller capabilities %v", mode, caps.controller)
+		}
+		if got := len(caps.node) > 0; got != d.runNode() {
+			t.Errorf("mode %s: unexpected node capabilities %v", mode, caps.node)
+		}
+
+		hasControllerService := false
+		for _, c := range caps.pluginService {
+			if c == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+				hasControllerService = true
+			}
+		}
+		if hasControllerService != d.runController() {
+			t.Errorf("mode %s: unexpected plugin capabilities %v", mode, caps.pluginService)
+		}
+	}
+
+	if _, err := NewCSIDriver(&config.Config{
+		DriverName: "csidriver.whou.io",
+		EndPoint:   "unix:///csi/csi.sock",
+		NodeID:     "node-1",
+		Mode:       "unknown",
+	}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
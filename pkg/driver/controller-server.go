@@ -3,9 +3,16 @@ package driver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/houwenchen/kubernetes-csi/pkg/lvm"
+	"github.com/houwenchen/kubernetes-csi/pkg/mount"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"k8s.io/klog/v2"
 )
 
@@ -35,9 +42,13 @@ var (
 		csi.ControllerServiceCapability_RPC_GET_VOLUME,
 		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
 		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
 		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
 	}
 
 	// CSIControllerServer volume 的能力集
@@ -54,20 +65,7 @@ type CSIControllerServer struct {
 }
 
 func NewDefaultCSIControllerServer(driver *CSIDriver) *CSIControllerServer {
-	capabilities := make([]*csi.ControllerServiceCapability, 0)
-
-	for _, RPCType := range defaultControllerServiceCapability_RPC_Types {
-		cap := &csi.ControllerServiceCapability{
-			Type: &csi.ControllerServiceCapability_Rpc{
-				Rpc: &csi.ControllerServiceCapability_RPC{
-					Type: RPCType,
-				},
-			}}
-
-		capabilities = append(capabilities, cap)
-	}
-
-	return NewCSIControllerServerWithOpt(driver, capabilities)
+	return NewCSIControllerServerWithOpt(driver, newControllerServiceCapabilities(defaultControllerServiceCapability_RPC_Types))
 }
 
 func NewCSIControllerServerWithOpt(driver *CSIDriver, opts ...[]*csi.ControllerServiceCapability) *CSIControllerServer {
@@ -83,24 +81,63 @@ func NewCSIControllerServerWithOpt(driver *CSIDriver, opts ...[]*csi.ControllerS
 	}
 }
 
+// lvm volume 只存在于所在的 node 上, 只运行 controller 服务时不在 vg 所在的 node 上, 不能执行 lvm 命令
+// 创建、删除等操作由每个 node 上以 all 模式运行的 driver 处理, 对应的 external-provisioner 使用 --node-deployment 部署
+func (ccs *CSIControllerServer) checkLocalLVM(method string) error {
+	if ccs.driver.runNode() {
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, "%s is not supported in %s mode: lvm volumes are only accessible on their own node, run the driver with --mode=%s on every node instead",
+		method, ModeController, ModeAll)
+}
+
 func (ccs *CSIControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	klog.Info("start create volume function")
 
+	if err := ccs.checkLocalLVM("CreateVolume"); err != nil {
+		return nil, err
+	}
+
 	// 首先对创建 volume 的请求进行判断
 	err := ccs.validateCreateVolumeRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
-	// 生成唯一标识 volume 的 VolumeId, 使用 volume name 作为 VolumeId
-	volumeId := req.GetName()
+	// lvm volume 只能创建在当前 node 上
+	if err := ccs.driver.checkAccessibilityRequirements(req.GetAccessibilityRequirements()); err != nil {
+		return nil, err
+	}
 
-	// 生成 VolumeContext
-	volumeContext := make(map[string]string)
-	volumeContext["driver-name"] = ccs.driver.config.DriverName
-	volumeContext["volume-name"] = req.GetName()
+	name := req.GetName()
+	if acquired := ccs.driver.volumeLocks.TryAcquire(name); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, name)
+	}
+	defer ccs.driver.volumeLocks.Release(name)
 
-	// paras := req.GetParameters()
+	// 同名 volume 已存在时, 兼容则直接返回, 保证幂等性
+	// vg 可能是按 vgpattern 选出来的, 重试时需要在所有 vg 中查找
+	existing, err := lvm.GetLogicalVolume("", name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if existing, err = ccs.checkExistingVolume(existing, req); err != nil {
+			return nil, err
+		}
+	}
+	if existing != nil {
+		klog.Infof("volume %s already exists in vg %s", name, existing.VGName)
+		return &csi.CreateVolumeResponse{
+			Volume: &csi.Volume{
+				CapacityBytes:      existing.Size,
+				VolumeId:           lvm.VolumeID(existing.VGName, existing.Name),
+				VolumeContext:      ccs.newVolumeContext(existing.Name, existing.VGName, existing.LayoutContext(), existing.CacheContext()),
+				ContentSource:      req.GetVolumeContentSource(),
+				AccessibleTopology: ccs.driver.accessibleTopology(),
+			},
+		}, nil
+	}
 
 	// create LV instance for create
 	lvInstance, err := lvm.NewLogicalVolumeForCreate(ccs.driver.config, req)
@@ -108,20 +145,150 @@ func (ccs *CSIControllerServer) CreateVolume(ctx context.Context, req *csi.Creat
 		return nil, err
 	}
 
-	if err = lvm.CreateLogicalVolume(lvInstance); err != nil {
+	// 生成唯一标识 volume 的 VolumeId, 格式为 <vg>/<lv>, 其他操作都使用 VolumeId 加锁
+	volumeId := lvm.VolumeID(lvInstance.VGName, lvInstance.Name)
+	if acquired := ccs.driver.volumeLocks.TryAcquire(volumeId); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeId)
+	}
+	defer ccs.driver.volumeLocks.Release(volumeId)
+
+	// 生成 VolumeContext, 带上 lv 的布局和缓存配置方便查看
+	volumeContext := ccs.newVolumeContext(lvInstance.Name, lvInstance.VGName, lvInstance.Layout.VolumeContext(), lvInstance.Cache.VolumeContext())
+
+	// 有数据源时从 snapshot 或者 volume 创建
+	if contentSource := req.GetVolumeContentSource(); contentSource != nil {
+		source, err := ccs.getVolumeContentSource(contentSource)
+		if err != nil {
+			return nil, err
+		}
+		if err = lvm.CreateLogicalVolumeFromSource(lvInstance, source); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = lvm.CreateLogicalVolume(lvInstance); err != nil {
+			return nil, err
+		}
+	}
+
+	// lvm 会按 extent 对齐, 返回 lv 的实际大小
+	created, err := lvm.GetLogicalVolume(lvInstance.VGName, lvInstance.Name)
+	if err != nil {
 		return nil, err
 	}
+	if created == nil {
+		return nil, status.Errorf(codes.Internal, "volume %s not found after creation", volumeId)
+	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			CapacityBytes: req.GetCapacityRange().RequiredBytes,
-			VolumeId:      volumeId,
-			VolumeContext: volumeContext,
-			ContentSource: req.GetVolumeContentSource(),
+			CapacityBytes:      created.Size,
+			VolumeId:           volumeId,
+			VolumeContext:      volumeContext,
+			ContentSource:      req.GetVolumeContentSource(),
+			AccessibleTopology: ccs.driver.accessibleTopology(),
 		},
 	}, nil
 }
 
+// 生成 VolumeContext, node 端需要根据 vgname 找到 lv 对应的设备, extra 为 lv 的布局、缓存等配置
+func (ccs *CSIControllerServer) newVolumeContext(name, vgname string, extra ...map[string]string) map[string]string {
+	volumeContext := map[string]string{
+		"driver-name":          ccs.driver.config.DriverName,
+		"volume-name":          name,
+		volumeContextKeyVGName: vgname,
+	}
+	for _, m := range extra {
+		for k, v := range m {
+			volumeContext[k] = v
+		}
+	}
+	return volumeContext
+}
+
+// 检查已存在的同名 lv 是否与请求兼容, 不兼容时返回 AlreadyExists
+// vg、thin pool、数据源、布局、缓存和大小都需要一致
+// 从数据源创建时中断遗留的 lv 数据不完整, 删除后返回 nil, 由调用方重新创建
+func (ccs *CSIControllerServer) checkExistingVolume(existing *lvm.LVInfo, req *csi.CreateVolumeRequest) (*lvm.LVInfo, error) {
+	if !lvm.IsOwnedBy(existing, ccs.driver.config.DriverName) || lvm.IsSnapshot(existing) {
+		return nil, status.Errorf(codes.AlreadyExists, "lv %s/%s already exists but is not a volume of driver %s", existing.VGName, existing.Name, ccs.driver.config.DriverName)
+	}
+
+	if lvm.IsIncompleteClone(existing) {
+		if err := lvm.RemoveIncompleteClone(existing); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	matched, err := lvm.MatchVolumeGroup(req.GetParameters(), existing.VGName)
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists in vg %s which doesn't match the parameters", existing.Name, existing.VGName)
+	}
+
+	if !lvm.MatchThinPool(req.GetParameters(), existing) {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists in thin pool %q which doesn't match the parameters", existing.Name, existing.ThinPool())
+	}
+
+	if !lvm.MatchContentSource(req.GetVolumeContentSource(), existing) {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with a different volume content source", existing.Name)
+	}
+
+	if matched, err = lvm.MatchLayout(req.GetParameters(), existing); err != nil {
+		return nil, err
+	}
+	if !matched {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with layout %v which doesn't match the parameters", existing.Name, existing.LayoutContext())
+	}
+
+	if matched, err = lvm.MatchCache(req.GetParameters(), existing); err != nil {
+		return nil, err
+	}
+	if !matched {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with cache %v which doesn't match the parameters", existing.Name, existing.CacheContext())
+	}
+
+	capRange := req.GetCapacityRange()
+	if existing.Size < capRange.GetRequiredBytes() {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with smaller size %d, required %d", existing.Name, existing.Size, capRange.GetRequiredBytes())
+	}
+	if limit := capRange.GetLimitBytes(); limit > 0 && existing.Size > limit {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with larger size %d, limit %d", existing.Name, existing.Size, limit)
+	}
+
+	return existing, nil
+}
+
+// 查找数据源对应的 lv
+func (ccs *CSIControllerServer) getVolumeContentSource(contentSource *csi.VolumeContentSource) (*lvm.LVInfo, error) {
+	switch {
+	case contentSource.GetSnapshot() != nil:
+		snapshotID := contentSource.GetSnapshot().GetSnapshotId()
+		source, err := lvm.GetSnapshot(ccs.driver.config.DriverName, snapshotID)
+		if err != nil {
+			return nil, err
+		}
+		if source == nil {
+			return nil, status.Errorf(codes.NotFound, "source snapshot %s not found", snapshotID)
+		}
+		return source, nil
+	case contentSource.GetVolume() != nil:
+		volumeID := contentSource.GetVolume().GetVolumeId()
+		source, err := lvm.GetOwnedVolumeByID(ccs.driver.config.DriverName, volumeID)
+		if err != nil {
+			return nil, err
+		}
+		if source == nil {
+			return nil, status.Errorf(codes.NotFound, "source volume %s not found", volumeID)
+		}
+		return source, nil
+	}
+
+	return nil, status.Error(codes.InvalidArgument, "unsupported volume content source")
+}
+
 // 对 CreateVolumeRequest 的必选字段进行校验
 func (ccs *CSIControllerServer) validateCreateVolumeRequest(req *csi.CreateVolumeRequest) error {
 	klog.Info("start validate create volume request")
@@ -130,16 +297,16 @@ func (ccs *CSIControllerServer) validateCreateVolumeRequest(req *csi.CreateVolum
 	// 1. 保证幂等性
 	// 2. 特殊需要时可以用这个字段来作为标识字段
 	if len(req.Name) == 0 {
-		return errors.New("volume's Name is required")
+		return status.Error(codes.InvalidArgument, "volume's Name is required")
 	}
 
 	// CreateVolumeRequest----VolumeCapabilities 字段检查
 	reqCaps := req.GetVolumeCapabilities()
 	if len(reqCaps) == 0 {
-		return errors.New("volume's capability is requored")
+		return status.Error(codes.InvalidArgument, "volume's capability is required")
 	}
 	if !ccs.validateVolumeCapabilitiesOfReq(reqCaps) {
-		return errors.New("unsupport VolumeCapability")
+		return status.Error(codes.InvalidArgument, "unsupport VolumeCapability")
 	}
 
 	return nil
@@ -147,6 +314,11 @@ func (ccs *CSIControllerServer) validateCreateVolumeRequest(req *csi.CreateVolum
 
 func (ccs *CSIControllerServer) validateVolumeCapabilitiesOfReq(caps []*csi.VolumeCapability) bool {
 	for _, c := range caps {
+		// 支持 mount 和 block 两种访问方式
+		if c.GetMount() == nil && c.GetBlock() == nil {
+			return false
+		}
+
 		found := false
 		for _, dc := range defaultVolumeCaps {
 			if dc.GetMode() == c.AccessMode.GetMode() {
@@ -165,17 +337,33 @@ func (ccs *CSIControllerServer) validateVolumeCapabilitiesOfReq(caps []*csi.Volu
 func (ccs *CSIControllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	klog.Info("start delete volume function")
 
+	if err := ccs.checkLocalLVM("DeleteVolume"); err != nil {
+		return nil, err
+	}
+
 	// 对 delete volume 的请求进行校验
 	if err := ccs.validateDeleteVolumeRequest(req); err != nil {
 		return nil, err
 	}
 
+	volumeID := req.GetVolumeId()
+	if acquired := ccs.driver.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer ccs.driver.volumeLocks.Release(volumeID)
+
 	// create LV instance for delete
-	lvInstance, err := lvm.NewLogicalVolumeForDelete(req)
+	lvInstance, err := lvm.NewLogicalVolumeForDelete(ccs.driver.config, req)
 	if err != nil {
 		return nil, err
 	}
 
+	// volume 已经不存在时直接返回成功, 保证幂等性
+	if lvInstance == nil {
+		klog.Infof("volume %s doesn't exist, treat it as deleted", req.GetVolumeId())
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
 	if err := lvm.RemoveLogicalVolume(lvInstance); err != nil {
 		return nil, err
 	}
@@ -190,57 +378,466 @@ func (ccs *CSIControllerServer) validateDeleteVolumeRequest(req *csi.DeleteVolum
 	// 检查 volume id
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
-		return errors.New("volume id is required")
+		return status.Error(codes.InvalidArgument, "volume id is required")
 	}
 
 	return nil
 }
 
 // TODO: 支持 EnableAttach，只有支持 attach 的时候才需要实现此方法
+// 没有声明 PUBLISH_UNPUBLISH_VOLUME 能力, sidecar 不会调用此方法
 func (ccs *CSIControllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	return nil, nil
+	return nil, status.Error(codes.Unimplemented, "ControllerPublishVolume is not supported")
 }
 
 // TODO: 支持 EnableAttach，只有支持 attach 的时候才需要实现此方法
+// 没有声明 PUBLISH_UNPUBLISH_VOLUME 能力, sidecar 不会调用此方法
 func (ccs *CSIControllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	return nil, nil
+	return nil, status.Error(codes.Unimplemented, "ControllerUnpublishVolume is not supported")
 }
 
+// 校验已存在的 volume 是否支持请求中的能力, 全部支持时原样返回请求中的能力和参数, 否则通过 Message 说明原因
 func (ccs *CSIControllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	return nil, nil
+	klog.Info("start validate volume capabilities function")
+
+	if err := ccs.checkLocalLVM("ValidateVolumeCapabilities"); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	reqCaps := req.GetVolumeCapabilities()
+	if len(reqCaps) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume's capability is required")
+	}
+
+	lv, err := lvm.GetOwnedVolumeByID(ccs.driver.config.DriverName, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+	}
+
+	if !ccs.validateVolumeCapabilitiesOfReq(reqCaps) {
+		return &csi.ValidateVolumeCapabilitiesResponse{
+			Message: "unsupport VolumeCapability",
+		}, nil
+	}
+
+	if msg, err := ccs.validateFsTypeOfVolume(lv, reqCaps); err != nil || len(msg) > 0 {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: msg}, err
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: reqCaps,
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
 }
 
+// 检查 mount 方式请求的文件系统类型与 lv 上已有的文件系统是否一致, 不一致时返回原因
+// lv 上还没有文件系统时, node 端会按请求的类型格式化
+func (ccs *CSIControllerServer) validateFsTypeOfVolume(lv *lvm.LVInfo, caps []*csi.VolumeCapability) (string, error) {
+	var existingFsType string
+	probed := false
+
+	for _, c := range caps {
+		fsType := c.GetMount().GetFsType()
+		if c.GetMount() == nil || len(fsType) == 0 {
+			continue
+		}
+		if !mount.IsSupportedFsType(fsType) {
+			return fmt.Sprintf("unsupported fsType %s", fsType), nil
+		}
+
+		// 未激活的 lv 没有设备文件, 无法探测文件系统
+		if !lv.IsActive() {
+			klog.Infof("lv %s/%s is not active, skip fsType check", lv.VGName, lv.Name)
+			return "", nil
+		}
+
+		if !probed {
+			format, err := mount.GetDiskFormat(lv.Path)
+			if err != nil {
+				return "", err
+			}
+			existingFsType, probed = format, true
+		}
+		if len(existingFsType) > 0 && existingFsType != fsType {
+			return fmt.Sprintf("volume %s is formatted as %s, requested fsType %s", lv.Name, existingFsType, fsType), nil
+		}
+	}
+
+	return "", nil
+}
+
+// 列出所有 vg 中由 driver 创建的 volume, 支持分页
 func (ccs *CSIControllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	return nil, nil
+	klog.Info("start list volumes function")
+
+	if err := ccs.checkLocalLVM("ListVolumes"); err != nil {
+		return nil, err
+	}
+
+	volumes, err := lvm.ListOwnedLogicalVolumes(ccs.driver.config.DriverName)
+	if err != nil {
+		return nil, err
+	}
+
+	// 保证分页时顺序稳定
+	sort.Slice(volumes, func(i, j int) bool {
+		return lvm.VolumeID(volumes[i].VGName, volumes[i].Name) < lvm.VolumeID(volumes[j].VGName, volumes[j].Name)
+	})
+	ids := make([]string, 0, len(volumes))
+	for _, lv := range volumes {
+		ids = append(ids, lvm.VolumeID(lv.VGName, lv.Name))
+	}
+
+	start, end, nextToken, err := paginate(ids, req.GetMaxEntries(), req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := lvm.ListThinPools()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, end-start)
+	for _, lv := range volumes[start:end] {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: ccs.newCSIVolume(lv),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: ccs.publishedNodeIDs(lv),
+				VolumeCondition:  ccs.driver.newVolumeCondition(lv, pools),
+			},
+		})
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
 }
 
+// lv 只存在于本 node 上, 已激活的 lv 有设备文件, 可以 publish 到本 node
+// 只运行 controller 服务时不会访问 lvm, 这里的 lv 都是本 node 上查询到的
+func (ccs *CSIControllerServer) publishedNodeIDs(lv *lvm.LVInfo) []string {
+	if lv.IsActive() {
+		return []string{ccs.driver.config.NodeID}
+	}
+	return nil
+}
+
+// 根据 lv 生成 csi.Volume
+func (ccs *CSIControllerServer) newCSIVolume(lv *lvm.LVInfo) *csi.Volume {
+	return &csi.Volume{
+		CapacityBytes:      lv.Size,
+		VolumeId:           lvm.VolumeID(lv.VGName, lv.Name),
+		VolumeContext:      ccs.newVolumeContext(lv.Name, lv.VGName, lv.LayoutContext(), lv.CacheContext()),
+		AccessibleTopology: ccs.driver.accessibleTopology(),
+	}
+}
+
+// 返回参数能够使用的 vg 的空闲容量, 指定 thinpool 时返回 thin pool 的空闲容量, 只统计当前 node 上的容量
 func (ccs *CSIControllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	return nil, nil
+	klog.Info("start get capacity function")
+
+	if err := ccs.checkLocalLVM("GetCapacity"); err != nil {
+		return nil, err
+	}
+
+	// 其他 node 上的容量由对应 node 上的 driver 统计
+	if !ccs.driver.isAccessibleFrom(req.GetAccessibleTopology()) {
+		klog.Infof("topology %v is not accessible from node %s", req.GetAccessibleTopology().GetSegments(), ccs.driver.config.NodeID)
+		return &csi.GetCapacityResponse{}, nil
+	}
+
+	paras := req.GetParameters()
+	vgname := paras[lvm.VGNameParam]
+
+	var capacity *lvm.Capacity
+	var err error
+	if pool := paras[lvm.ThinPoolParam]; len(pool) > 0 {
+		if len(vgname) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is required when %s is specified", lvm.VGNameParam, lvm.ThinPoolParam)
+		}
+		var ratio float64
+		if ratio, err = lvm.OverprovisionRatio(paras, ccs.driver.config.ThinPoolOverprovisionRatio); err != nil {
+			return nil, err
+		}
+		capacity, err = lvm.GetThinPoolCapacity(vgname, pool, ratio)
+		if errors.Is(err, lvm.ErrLVNotFound) {
+			// thin pool 会在第一次创建 volume 时自动创建
+			capacity, err = lvm.EstimateThinPoolCapacity(vgname, pool, paras, ratio)
+		}
+	} else {
+		capacity, err = lvm.GetVGCapacity(paras)
+	}
+	if err != nil {
+		// 当前 node 上没有对应的 vg 或者 thin pool 时容量为 0
+		if errors.Is(err, lvm.ErrVGNotFound) || errors.Is(err, lvm.ErrLVNotFound) {
+			klog.Infof("no capacity for parameters %v: %v", paras, err)
+			return &csi.GetCapacityResponse{}, nil
+		}
+		return nil, err
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: capacity.Available,
+		MaximumVolumeSize: wrapperspb.Int64(capacity.MaximumVolumeSize),
+	}, nil
 }
 
 func (ccs *CSIControllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	return nil, nil
+	klog.Info("start ControllerGetCapabilities function")
+
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: ccs.capabilities,
+	}, nil
 }
 
-// TODO: snapshot 功能后续支持
+// 使用 lvcreate --snapshot 创建 snapshot, origin 位于 thin pool 时创建 thin snapshot
 func (ccs *CSIControllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, nil
+	klog.Info("start create snapshot function")
+
+	if err := ccs.checkLocalLVM("CreateSnapshot"); err != nil {
+		return nil, err
+	}
+
+	if err := ccs.validateCreateSnapshotRequest(req); err != nil {
+		return nil, err
+	}
+
+	// 对 source volume 加锁, 避免创建 snapshot 的过程中 volume 被删除或扩容
+	sourceVolumeID := req.GetSourceVolumeId()
+	if acquired := ccs.driver.volumeLocks.TryAcquire(sourceVolumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, sourceVolumeID)
+	}
+	defer ccs.driver.volumeLocks.Release(sourceVolumeID)
+
+	// 同名 snapshot 已存在时, source 相同则直接返回, 保证幂等性
+	existing, err := lvm.GetLogicalVolume("", lvm.SnapshotLVName(req.GetName()))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if !lvm.IsSnapshot(existing) || !lvm.IsOwnedBy(existing, ccs.driver.config.DriverName) || !lvm.IsSnapshotOf(existing, req.GetSourceVolumeId()) {
+			return nil, status.Errorf(codes.AlreadyExists, "snapshot %s already exists but is incompatible with source volume %s", req.GetName(), req.GetSourceVolumeId())
+		}
+		return &csi.CreateSnapshotResponse{
+			Snapshot: newCSISnapshot(existing),
+		}, nil
+	}
+
+	snapInstance, err := lvm.NewSnapshotForCreate(ccs.driver.config, req)
+	if err != nil {
+		return nil, err
+	}
+
+	lv, err := lvm.CreateSnapshot(snapInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: newCSISnapshot(lv),
+	}, nil
 }
 
-// TODO: snapshot 功能后续支持
+// 对 CreateSnapshotRequest 的必选字段进行校验
+func (ccs *CSIControllerServer) validateCreateSnapshotRequest(req *csi.CreateSnapshotRequest) error {
+	klog.Info("start validate create snapshot request")
+
+	if len(req.GetName()) == 0 {
+		return status.Error(codes.InvalidArgument, "snapshot's Name is required")
+	}
+
+	if len(req.GetSourceVolumeId()) == 0 {
+		return status.Error(codes.InvalidArgument, "snapshot's source volume id is required")
+	}
+
+	return nil
+}
+
+// snapshot 不存在时直接返回成功
 func (ccs *CSIControllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, nil
+	klog.Info("start delete snapshot function")
+
+	if err := ccs.checkLocalLVM("DeleteSnapshot"); err != nil {
+		return nil, err
+	}
+
+	snapshotID := req.GetSnapshotId()
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "snapshot id is required")
+	}
+
+	if acquired := ccs.driver.volumeLocks.TryAcquire(snapshotID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, snapshotID)
+	}
+	defer ccs.driver.volumeLocks.Release(snapshotID)
+
+	if _, _, err := lvm.ParseSnapshotID(snapshotID); err != nil {
+		klog.Infof("snapshot id %s is not created by this driver, treat it as deleted", snapshotID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	lv, err := lvm.GetSnapshot(ccs.driver.config.DriverName, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil {
+		klog.Infof("snapshot %s doesn't exist, treat it as deleted", snapshotID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	if err := lvm.RemoveSnapshot(lv.VGName, lv.Name); err != nil {
+		return nil, err
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
-// TODO: snapshot 功能后续支持
+// 支持按 snapshot id 和 source volume id 过滤, 以及分页
 func (ccs *CSIControllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	return nil, nil
+	klog.Info("start list snapshots function")
+
+	if err := ccs.checkLocalLVM("ListSnapshots"); err != nil {
+		return nil, err
+	}
+
+	snapshots, err := lvm.ListSnapshots(ccs.driver.config.DriverName)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []*lvm.LVInfo
+	for _, lv := range snapshots {
+		if len(req.GetSnapshotId()) > 0 && lvm.SnapshotID(lv) != req.GetSnapshotId() {
+			continue
+		}
+		if len(req.GetSourceVolumeId()) > 0 && !lvm.IsSnapshotOf(lv, req.GetSourceVolumeId()) {
+			continue
+		}
+		filtered = append(filtered, lv)
+	}
+
+	// 保证分页时顺序稳定
+	sort.Slice(filtered, func(i, j int) bool {
+		return lvm.SnapshotID(filtered[i]) < lvm.SnapshotID(filtered[j])
+	})
+	ids := make([]string, 0, len(filtered))
+	for _, lv := range filtered {
+		ids = append(ids, lvm.SnapshotID(lv))
+	}
+
+	start, end, nextToken, err := paginate(ids, req.GetMaxEntries(), req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, end-start)
+	for _, lv := range filtered[start:end] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{
+			Snapshot: newCSISnapshot(lv),
+		})
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+// 根据 snapshot lv 生成 csi.Snapshot
+func newCSISnapshot(lv *lvm.LVInfo) *csi.Snapshot {
+	return &csi.Snapshot{
+		SizeBytes:      lvm.SourceSize(lv),
+		SnapshotId:     lvm.SnapshotID(lv),
+		SourceVolumeId: lv.SnapshotSource(),
+		CreationTime:   timestamppb.New(lv.CreateTime),
+		ReadyToUse:     true,
+	}
 }
 
+// 使用 lvextend 扩容 lv, 文件系统的扩容由 NodeExpandVolume 完成
+// 只运行 controller 服务时不能执行 lvextend, lv 和文件系统都交给 volume 所在 node 上的 NodeExpandVolume 扩容
 func (ccs *CSIControllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	return nil, nil
+	klog.Info("start controller expand volume function")
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	capRange := req.GetCapacityRange()
+	if capRange == nil || capRange.GetRequiredBytes() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "capacity range with required bytes is required")
+	}
+
+	if !ccs.driver.runNode() {
+		klog.Infof("volume %s will be extended by NodeExpandVolume on its node", volumeID)
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         capRange.GetRequiredBytes(),
+			NodeExpansionRequired: true,
+		}, nil
+	}
+
+	if acquired := ccs.driver.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer ccs.driver.volumeLocks.Release(volumeID)
+
+	capacity, err := ccs.driver.extendVolume(volumeID, capRange)
+	if err != nil {
+		return nil, err
+	}
+
+	// block 模式下不需要 node 端扩容文件系统
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         capacity,
+		NodeExpansionRequired: req.GetVolumeCapability().GetBlock() == nil,
+	}, nil
 }
 
+// 查询 volume 的大小和状态, lv 异常时 VolumeCondition.Abnormal 为 true
 func (ccs *CSIControllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	return nil, nil
+	klog.Info("start controller get volume function")
+
+	if err := ccs.checkLocalLVM("ControllerGetVolume"); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	lv, err := lvm.GetOwnedVolumeByID(ccs.driver.config.DriverName, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+	}
+
+	pools, err := lvm.ListThinPools()
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: ccs.newCSIVolume(lv),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: ccs.publishedNodeIDs(lv),
+			VolumeCondition:  ccs.driver.newVolumeCondition(lv, pools),
+		},
+	}, nil
 }
@@ -0,0 +1,135 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/config"
+	"github.com/houwenchen/kubernetes-csi/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestControllerServer(t *testing.T) *CSIControllerServer {
+	d, err := NewCSIDriver(&config.Config{
+		DriverName: "csidriver.whou.io",
+		EndPoint:   "unix:///csi/csi.sock",
+		NodeID:     "node-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDefaultCSIControllerServer(d)
+}
+
+func mountCapability(fsType string, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestValidateVolumeCapabilitiesInvalidArgument(t *testing.T) {
+	cs := newTestControllerServer(t)
+
+	cases := []*csi.ValidateVolumeCapabilitiesRequest{
+		{VolumeCapabilities: []*csi.VolumeCapability{mountCapability("", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}},
+		{VolumeId: "pvc-1"},
+	}
+	for _, req := range cases {
+		if _, err := cs.ValidateVolumeCapabilities(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument for %v, got %v", req, err)
+		}
+	}
+}
+
+func TestValidateVolumeCapabilitiesOfReq(t *testing.T) {
+	cs := newTestControllerServer(t)
+
+	block := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	if !cs.validateVolumeCapabilitiesOfReq([]*csi.VolumeCapability{block, mountCapability("xfs", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}) {
+		t.Error("expected block and mount capabilities to be supported")
+	}
+	if cs.validateVolumeCapabilitiesOfReq([]*csi.VolumeCapability{mountCapability("", csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)}) {
+		t.Error("expected MULTI_NODE_MULTI_WRITER to be unsupported")
+	}
+}
+
+func TestValidateFsTypeOfVolume(t *testing.T) {
+	cs := newTestControllerServer(t)
+	lv := &lvm.LVInfo{Name: "pvc-1", VGName: "lvmvg", Attr: "-wi-------"}
+
+	msg, err := cs.validateFsTypeOfVolume(lv, []*csi.VolumeCapability{mountCapability("btrfs", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)})
+	if err != nil || len(msg) == 0 {
+		t.Errorf("expected unsupported fsType message, got %q, %v", msg, err)
+	}
+
+	// 未激活的 lv 不探测文件系统
+	msg, err = cs.validateFsTypeOfVolume(lv, []*csi.VolumeCapability{mountCapability("ext4", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)})
+	if err != nil || len(msg) > 0 {
+		t.Errorf("expected ext4 to be accepted, got %q, %v", msg, err)
+	}
+}
+
+func TestControllerModeWithoutLVM(t *testing.T) {
+	d, err := NewCSIDriver(&config.Config{
+		DriverName: "csidriver.whou.io",
+		EndPoint:   "unix:///csi/csi.sock",
+		NodeID:     "controller-1",
+		Mode:       ModeController,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewDefaultCSIControllerServer(d)
+
+	// 只运行 controller 服务时不能在本机执行 lvm 命令
+	_, err = cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+		Parameters:         map[string]string{lvm.VGNameParam: "lvmvg"},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for CreateVolume, got %v", err)
+	}
+	if _, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "lvmvg/pvc-1"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for DeleteVolume, got %v", err)
+	}
+
+	// 扩容交给 volume 所在 node 上的 NodeExpandVolume 完成
+	resp, err := cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "lvmvg/pvc-1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 << 30},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		},
+	})
+	if err != nil || resp.GetCapacityBytes() != 2<<30 || !resp.GetNodeExpansionRequired() {
+		t.Errorf("expected expansion to be delegated to node, got %v, %v", resp, err)
+	}
+
+	caps := d.detectCapabilities()
+	if len(caps.controller) != 1 || caps.controller[0] != csi.ControllerServiceCapability_RPC_EXPAND_VOLUME {
+		t.Errorf("unexpected controller capabilities in controller mode: %v", caps.controller)
+	}
+}
+
+func TestPublishedNodeIDs(t *testing.T) {
+	cs := newTestControllerServer(t)
+
+	// 已激活的 lv 可以在本 node 上使用
+	active := &lvm.LVInfo{Name: "pvc-1", VGName: "lvmvg", Attr: "-wi-a-----"}
+	if ids := cs.publishedNodeIDs(active); len(ids) != 1 || ids[0] != "node-1" {
+		t.Errorf("unexpected published nodes of active lv: %v", ids)
+	}
+	inactive := &lvm.LVInfo{Name: "pvc-2", VGName: "lvmvg", Attr: "-wi-------"}
+	if ids := cs.publishedNodeIDs(inactive); len(ids) != 0 {
+		t.Errorf("unexpected published nodes of inactive lv: %v", ids)
+	}
+}
@@ -2,13 +2,31 @@ package driver
 
 import (
 	"errors"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
 
 	"github.com/houwenchen/kubernetes-csi/pkg/config"
+	"github.com/houwenchen/kubernetes-csi/pkg/lvm"
 	"k8s.io/klog/v2"
 )
 
+// driver 的运行模式, 决定注册哪些 gRPC 服务
+const (
+	// 只运行 controller 服务, 不访问 lvm, 只负责把扩容交给 volume 所在的 node
+	// lvm volume 只存在于所在的 node 上, 创建、删除等操作由 node 上以 all 模式运行的 driver 处理
+	ModeController = "controller"
+	// 只运行 node 服务, 负责本节点上的挂载等操作
+	ModeNode = "node"
+	// 同时运行 controller 和 node 服务, 配合 --node-deployment 的 external-provisioner 部署在每个 node 上
+	ModeAll = "all"
+)
+
 type CSIDriver struct {
 	config *config.Config
+
+	// controller 和 node 共用, 保证同一个 volume 上的操作串行执行
+	volumeLocks *VolumeLocks
 }
 
 func NewCSIDriver(cfg *config.Config) (*CSIDriver, error) {
@@ -27,20 +45,73 @@ func NewCSIDriver(cfg *config.Config) (*CSIDriver, error) {
 		return nil, errors.New("miss driver endpoint")
 	}
 
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = ModeAll
+	case ModeController, ModeNode, ModeAll:
+	default:
+		klog.Infof("unknown driver mode %s", cfg.Mode)
+		return nil, fmt.Errorf("unknown mode %s, must be one of %s, %s, %s", cfg.Mode, ModeController, ModeNode, ModeAll)
+	}
+
 	return &CSIDriver{
-		config: cfg,
+		config:      cfg,
+		volumeLocks: NewVolumeLocks(),
 	}, nil
 }
 
 func (d *CSIDriver) Run() error {
+	// node 上的 vg 需要在发现 volume 和探测能力之前准备好
+	if d.runNode() {
+		if err := lvm.ProvisionVolumeGroup(d.config); err != nil {
+			return fmt.Errorf("provision vg %s failed: %v", d.config.ProvisionVGName, err)
+		}
+	}
+
+	// volume 信息全部保存在 lvm 中, 启动时从本 node 的 lvm 中发现已有的 volume
+	// 只运行 controller 服务时不在 vg 所在的 node 上, 不执行 lvm 命令
+	if d.runNode() {
+		lvs, err := lvm.ListOwnedLogicalVolumes(d.config.DriverName)
+		if err != nil {
+			klog.Errorf("discover existing volumes failed: %v", err)
+		} else {
+			klog.Infof("discovered %d existing volumes of driver %s", len(lvs), d.config.DriverName)
+		}
+	}
+
+	// 能力集根据节点环境探测, 配置中指定时以配置为准
+	caps, err := d.resolveCapabilities()
+	if err != nil {
+		return err
+	}
+
 	s := NewNonBlockingGRPCServer()
 
-	ids := NewDefaultCSIIdentityServer(d)
-	cs := NewDefaultCSIControllerServer(d)
-	ns := NewDefaultCSINodeServer(d)
+	// 根据运行模式注册服务, 未注册的服务保持 nil interface
+	var cs csi.ControllerServer
+	var ns csi.NodeServer
+
+	ids := NewCSIIdentityServerWithOpt(d, newPluginCapabilities(caps.pluginService, caps.pluginExpansion))
+	if d.runController() {
+		cs = NewCSIControllerServerWithOpt(d, newControllerServiceCapabilities(caps.controller))
+	}
+	if d.runNode() {
+		ns = NewCSINodeServerWithOpt(d, newNodeServiceCapabilities(caps.node))
+	}
+	klog.Infof("driver %s is running in %s mode", d.config.DriverName, d.config.Mode)
 
 	s.Start(d.config.EndPoint, ids, cs, ns)
 	s.Wait()
 
 	return nil
 }
+
+// 是否运行 controller 服务
+func (d *CSIDriver) runController() bool {
+	return d.config.Mode == ModeController || d.config.Mode == ModeAll
+}
+
+// 是否运行 node 服务
+func (d *CSIDriver) runNode() bool {
+	return d.config.Mode == ModeNode || d.config.Mode == ModeAll
+}
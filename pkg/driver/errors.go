@@ -0,0 +1,40 @@
+package driver
+
+import (
+	"errors"
+
+	"github.com/houwenchen/kubernetes-csi/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 将 lvm 模块的错误类型转换为对应的 gRPC status code, sidecar 根据 code 判断是否需要重试
+// 已经是 status 的错误保持不变, 无法识别的错误统一返回 Internal
+func toStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code := codes.Internal
+	switch {
+	case errors.Is(err, lvm.ErrInvalidArgument):
+		code = codes.InvalidArgument
+	case errors.Is(err, lvm.ErrOutOfRange):
+		code = codes.OutOfRange
+	case errors.Is(err, lvm.ErrVGNotFound), errors.Is(err, lvm.ErrLVNotFound):
+		code = codes.NotFound
+	case errors.Is(err, lvm.ErrLVExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, lvm.ErrInsufficientSpace):
+		code = codes.ResourceExhausted
+	case errors.Is(err, lvm.ErrBusy):
+		code = codes.Aborted
+	case errors.Is(err, lvm.ErrInUse), errors.Is(err, lvm.ErrHasSnapshots):
+		code = codes.FailedPrecondition
+	}
+
+	return status.Error(code, err.Error())
+}
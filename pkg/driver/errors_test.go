@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/houwenchen/kubernetes-csi/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatusError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{fmt.Errorf("%w: miss vgname", lvm.ErrInvalidArgument), codes.InvalidArgument},
+		{fmt.Errorf("%w: lvmvg", lvm.ErrVGNotFound), codes.NotFound},
+		{fmt.Errorf("%w: lvmvg", lvm.ErrInsufficientSpace), codes.ResourceExhausted},
+		{fmt.Errorf("%w: lvmvg/pvc-1", lvm.ErrLVExists), codes.AlreadyExists},
+		{fmt.Errorf("%w: lvmvg", lvm.ErrBusy), codes.Aborted},
+		{fmt.Errorf("%w: lvmvg/pvc-1", lvm.ErrInUse), codes.FailedPrecondition},
+		{fmt.Errorf("%w: lvmvg/pvc-1", lvm.ErrHasSnapshots), codes.FailedPrecondition},
+		{status.Error(codes.FailedPrecondition, "keep"), codes.FailedPrecondition},
+		{errors.New("unknown"), codes.Internal},
+	}
+
+	for _, c := range cases {
+		if code := status.Code(toStatusError(c.err)); code != c.code {
+			t.Errorf("error %v: expected code %v, got %v", c.err, c.code, code)
+		}
+	}
+
+	if toStatusError(nil) != nil {
+		t.Error("expected nil for nil error")
+	}
+}
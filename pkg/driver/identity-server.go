@@ -20,6 +20,11 @@ type IdentityServer interface {
 var (
 	defaultPluginCapability_Service_Types = []csi.PluginCapability_Service_Type{
 		csi.PluginCapability_Service_CONTROLLER_SERVICE,
+		csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+	}
+
+	defaultPluginCapability_VolumeExpansion_Types = []csi.PluginCapability_VolumeExpansion_Type{
+		csi.PluginCapability_VolumeExpansion_ONLINE,
 	}
 )
 
@@ -29,20 +34,7 @@ type CSIIdentityServer struct {
 }
 
 func NewDefaultCSIIdentityServer(driver *CSIDriver) *CSIIdentityServer {
-	capabilities := make([]*csi.PluginCapability, 0)
-
-	for _, svcType := range defaultPluginCapability_Service_Types {
-		cap := &csi.PluginCapability{
-			Type: &csi.PluginCapability_Service_{
-				Service: &csi.PluginCapability_Service{
-					Type: svcType,
-				},
-			}}
-
-		capabilities = append(capabilities, cap)
-	}
-
-	return NewCSIIdentityServerWithOpt(driver, capabilities)
+	return NewCSIIdentityServerWithOpt(driver, newPluginCapabilities(defaultPluginCapability_Service_Types, defaultPluginCapability_VolumeExpansion_Types))
 }
 
 func NewCSIIdentityServerWithOpt(driver *CSIDriver, opts ...[]*csi.PluginCapability) *CSIIdentityServer {
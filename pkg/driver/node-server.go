@@ -2,8 +2,14 @@ package driver
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/lvm"
+	"github.com/houwenchen/kubernetes-csi/pkg/mount"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 )
 
@@ -23,7 +29,10 @@ type NodeServer interface {
 
 var (
 	defaultNodeServiceCapability_RPC_Types = []csi.NodeServiceCapability_RPC_Type{
-		csi.NodeServiceCapability_RPC_UNKNOWN,
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	}
 )
 
@@ -33,20 +42,7 @@ type CSINodeServer struct {
 }
 
 func NewDefaultCSINodeServer(driver *CSIDriver) *CSINodeServer {
-	capabilities := make([]*csi.NodeServiceCapability, 0)
-
-	for _, RPCType := range defaultNodeServiceCapability_RPC_Types {
-		cap := &csi.NodeServiceCapability{
-			Type: &csi.NodeServiceCapability_Rpc{
-				Rpc: &csi.NodeServiceCapability_RPC{
-					Type: RPCType,
-				},
-			}}
-
-		capabilities = append(capabilities, cap)
-	}
-
-	return NewCSINodeServerWithOpt(driver, capabilities)
+	return NewCSINodeServerWithOpt(driver, newNodeServiceCapabilities(defaultNodeServiceCapability_RPC_Types))
 }
 
 func NewCSINodeServerWithOpt(driver *CSIDriver, opts ...[]*csi.NodeServiceCapability) *CSINodeServer {
@@ -63,43 +59,413 @@ func NewCSINodeServerWithOpt(driver *CSIDriver, opts ...[]*csi.NodeServiceCapabi
 }
 
 // capabilities 中有 NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME 时才需要实现此方法
+// 将 lv 格式化 (仅空白设备) 并挂载到全局的 staging 目录
 func (cns *CSINodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	klog.Info("start NodeStageVolume function")
 
+	if err := cns.validateNodeStageVolumeRequest(req); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if acquired := cns.driver.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer cns.driver.volumeLocks.Release(volumeID)
+
+	stagingPath := req.GetStagingTargetPath()
+
+	devicePath, err := getDevicePath(cns.driver.config.VolumeDir, volumeID, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+
+	// block 模式不需要格式化和挂载, 只需要确认设备存在
+	if req.GetVolumeCapability().GetBlock() != nil {
+		if _, err := os.Stat(devicePath); err != nil {
+			return nil, status.Errorf(codes.NotFound, "device %s of volume %s is not available: %v", devicePath, volumeID, err)
+		}
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	mnt := req.GetVolumeCapability().GetMount()
+	if mnt == nil {
+		return nil, status.Error(codes.InvalidArgument, "unsupported access type, only mount and block are supported")
+	}
+
+	fsType := mnt.GetFsType()
+	if len(fsType) == 0 {
+		fsType = mount.DefaultFsType
+	}
+	if !mount.IsSupportedFsType(fsType) {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported fsType: %s", fsType)
+	}
+
+	// 已经挂载过的直接返回, 保证幂等性
+	mounted, err := mount.IsMountPoint(stagingPath)
+	if err != nil {
+		return nil, err
+	}
+	if mounted {
+		klog.Infof("volume %s is already staged at %s", volumeID, stagingPath)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	if err := os.MkdirAll(stagingPath, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "create staging path %s failed: %v", stagingPath, err)
+	}
+
+	if err := mount.FormatAndMount(devicePath, stagingPath, fsType, mnt.GetMountFlags()); err != nil {
+		return nil, err
+	}
+
+	klog.Infof("volume %s staged at %s", volumeID, stagingPath)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// 对 NodeStageVolumeRequest 的必选字段进行校验
+func (cns *CSINodeServer) validateNodeStageVolumeRequest(req *csi.NodeStageVolumeRequest) error {
+	if len(req.GetVolumeId()) == 0 {
+		return status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	if len(req.GetStagingTargetPath()) == 0 {
+		return status.Error(codes.InvalidArgument, "staging target path is required")
+	}
+
+	if req.GetVolumeCapability() == nil {
+		return status.Error(codes.InvalidArgument, "volume capability is required")
+	}
+
+	return nil
+}
+
 // capabilities 中有 NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME 时才需要实现此方法
+// 卸载 staging 目录, 卸载失败时保留目录以便重试
 func (cns *CSINodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	klog.Info("start NodeUnstageVolume function")
 
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	stagingPath := req.GetStagingTargetPath()
+	if len(stagingPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "staging target path is required")
+	}
+
+	if acquired := cns.driver.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer cns.driver.volumeLocks.Release(volumeID)
+
+	if err := unmountAndRemove(stagingPath); err != nil {
+		return nil, err
+	}
+
+	klog.Infof("volume %s unstaged from %s", volumeID, stagingPath)
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+// mount 模式将 staging 目录 bind mount 到 pod 的 target 目录
+// block 模式将 lv 的设备文件 bind mount 到 target 文件
 func (cns *CSINodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	klog.Info("start NodePublishVolume function")
 
+	if err := cns.validateNodePublishVolumeRequest(req); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if acquired := cns.driver.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer cns.driver.volumeLocks.Release(volumeID)
+
+	var options []string
+	if req.GetReadonly() {
+		options = append(options, "ro")
+	}
+
+	volCap := req.GetVolumeCapability()
+	switch {
+	case volCap.GetBlock() != nil:
+		if err := cns.publishBlockVolume(req, options); err != nil {
+			return nil, err
+		}
+	case volCap.GetMount() != nil:
+		options = append(options, volCap.GetMount().GetMountFlags()...)
+		if err := cns.publishMountVolume(req, options); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unsupported access type, only mount and block are supported")
+	}
+
+	klog.Infof("volume %s published at %s", volumeID, req.GetTargetPath())
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+func (cns *CSINodeServer) publishMountVolume(req *csi.NodePublishVolumeRequest, options []string) error {
+	volumeID := req.GetVolumeId()
+	stagingPath := req.GetStagingTargetPath()
+	targetPath := req.GetTargetPath()
+
+	stagingMounts, err := mount.GetMountsByMountPoint(stagingPath)
+	if err != nil {
+		return err
+	}
+	if len(stagingMounts) == 0 {
+		return status.Errorf(codes.FailedPrecondition, "volume %s is not staged at %s", volumeID, stagingPath)
+	}
+
+	// target 已经是同一个设备的挂载点时直接返回, 保证幂等性
+	targetMounts, err := mount.GetMountsByMountPoint(targetPath)
+	if err != nil {
+		return err
+	}
+	if len(targetMounts) > 0 {
+		if !isSameDevice(stagingMounts[len(stagingMounts)-1], targetMounts[len(targetMounts)-1]) {
+			return status.Errorf(codes.AlreadyExists, "target path %s is already mounted by another device", targetPath)
+		}
+		klog.Infof("volume %s is already published at %s", volumeID, targetPath)
+		return nil
+	}
+
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		return status.Errorf(codes.Internal, "create target path %s failed: %v", targetPath, err)
+	}
+
+	return mount.BindMount(stagingPath, targetPath, options)
+}
+
+func (cns *CSINodeServer) publishBlockVolume(req *csi.NodePublishVolumeRequest, options []string) error {
+	volumeID := req.GetVolumeId()
+	targetPath := req.GetTargetPath()
+
+	devicePath, err := getDevicePath(cns.driver.config.VolumeDir, volumeID, req.GetVolumeContext())
+	if err != nil {
+		return err
+	}
+
+	// target 已经是同一个设备时直接返回, 保证幂等性
+	mounted, err := mount.IsMountPoint(targetPath)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		same, err := isSameDeviceFile(devicePath, targetPath)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return status.Errorf(codes.AlreadyExists, "target path %s is already mounted by another device", targetPath)
+		}
+		klog.Infof("volume %s is already published at %s", volumeID, targetPath)
+		return nil
+	}
+
+	// block 模式下 target 是一个文件, 由 driver 负责创建
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0750); err != nil {
+		return status.Errorf(codes.Internal, "create parent dir of target path %s failed: %v", targetPath, err)
+	}
+	f, err := os.OpenFile(targetPath, os.O_CREATE, 0660)
+	if err != nil {
+		return status.Errorf(codes.Internal, "create target file %s failed: %v", targetPath, err)
+	}
+	f.Close()
+
+	return mount.BindMount(devicePath, targetPath, options)
+}
+
+// 对 NodePublishVolumeRequest 的必选字段进行校验
+func (cns *CSINodeServer) validateNodePublishVolumeRequest(req *csi.NodePublishVolumeRequest) error {
+	if len(req.GetVolumeId()) == 0 {
+		return status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	// 支持 STAGE_UNSTAGE_VOLUME 时 staging 目录是必须的
+	if len(req.GetStagingTargetPath()) == 0 {
+		return status.Error(codes.InvalidArgument, "staging target path is required")
+	}
+
+	if len(req.GetTargetPath()) == 0 {
+		return status.Error(codes.InvalidArgument, "target path is required")
+	}
+
+	if req.GetVolumeCapability() == nil {
+		return status.Error(codes.InvalidArgument, "volume capability is required")
+	}
+
+	return nil
+}
+
+// 卸载 target 并删除, mount 模式下 target 为目录, block 模式下 target 为文件
 func (cns *CSINodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	klog.Info("start NodeUnpublishVolume function")
 
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	targetPath := req.GetTargetPath()
+	if len(targetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "target path is required")
+	}
+
+	if acquired := cns.driver.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer cns.driver.volumeLocks.Release(volumeID)
+
+	if err := unmountAndRemove(targetPath); err != nil {
+		return nil, err
+	}
+
+	klog.Infof("volume %s unpublished from %s", volumeID, targetPath)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
 // capabilities 中有 NodeServiceCapability_RPC_GET_VOLUME_STATS 时才需要实现此方法
+// mount 模式返回文件系统的容量和 inode 使用情况, block 模式只返回设备大小
 func (cns *CSINodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
 	klog.Info("start NodeGetVolumeStats function")
 
-	return &csi.NodeGetVolumeStatsResponse{}, nil
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	volumePath := req.GetVolumePath()
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume path is required")
+	}
+
+	info, err := os.Stat(volumePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %s not found", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "stat volume path %s failed: %v", volumePath, err)
+	}
+
+	lv, err := lvm.GetOwnedVolumeByID(cns.driver.config.DriverName, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+	}
+
+	var usage []*csi.VolumeUsage
+	if info.Mode()&os.ModeDevice != 0 {
+		usage = append(usage, &csi.VolumeUsage{
+			Total: lv.Size,
+			Unit:  csi.VolumeUsage_BYTES,
+		})
+	} else {
+		stats, err := mount.GetFsStats(volumePath)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		usage = append(usage, &csi.VolumeUsage{
+			Total:     stats.TotalBytes,
+			Available: stats.AvailableBytes,
+			Used:      stats.UsedBytes,
+			Unit:      csi.VolumeUsage_BYTES,
+		}, &csi.VolumeUsage{
+			Total:     stats.TotalInodes,
+			Available: stats.FreeInodes,
+			Used:      stats.UsedInodes,
+			Unit:      csi.VolumeUsage_INODES,
+		})
+	}
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage:           usage,
+		VolumeCondition: cns.volumeCondition(lv),
+	}, nil
+}
+
+// 生成 VolumeCondition, 挂了缓存的 lv 在 message 中带上缓存的命中统计
+func (cns *CSINodeServer) volumeCondition(lv *lvm.LVInfo) *csi.VolumeCondition {
+	pools, err := lvm.ListThinPools()
+	if err != nil {
+		klog.Warningf("list thin pools failed: %v", err)
+	}
+
+	condition := cns.driver.newVolumeCondition(lv, pools)
+	if !lv.IsCached() {
+		return condition
+	}
+
+	stats, err := lvm.GetCacheStats(lv)
+	if err != nil {
+		klog.Warningf("get cache stats of lv %s/%s failed: %v", lv.VGName, lv.Name, err)
+		return condition
+	}
+	condition.Message += "; " + stats.String()
+	return condition
 }
 
 // capabilities 中有 NodeServiceCapability_RPC_EXPAND_VOLUME 时才需要实现此方法
+// lv 小于请求的大小时先扩容 lv (controller 只运行 controller 服务时不会扩容 lv)
+// 再在挂载状态下扩容文件系统, ext4 使用 resize2fs, xfs 使用 xfs_growfs
 func (cns *CSINodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
 	klog.Info("start NodeExpandVolume function")
 
-	return nil, nil
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	volumePath := req.GetVolumePath()
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume path is required")
+	}
+
+	if acquired := cns.driver.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer cns.driver.volumeLocks.Release(volumeID)
+
+	// volume path 不存在时返回 NotFound, 不扩容 lv
+	isBlock, err := isBlockDevicePath(volumePath)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := req.GetCapacityRange().GetRequiredBytes()
+	if capacity > 0 {
+		if capacity, err = cns.driver.extendVolume(volumeID, req.GetCapacityRange()); err != nil {
+			return nil, err
+		}
+	}
+
+	// block 模式下没有文件系统需要扩容, 没有传 VolumeCapability 时根据 volume path 是否为块设备判断
+	if req.GetVolumeCapability().GetBlock() != nil || isBlock {
+		klog.Infof("volume %s is a block volume, skip filesystem expansion", volumeID)
+		return &csi.NodeExpandVolumeResponse{CapacityBytes: capacity}, nil
+	}
+
+	mounts, err := mount.GetMountsByMountPoint(volumePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(mounts) == 0 {
+		return nil, status.Errorf(codes.NotFound, "volume path %s of volume %s is not mounted", volumePath, volumeID)
+	}
+
+	// 通过挂载信息获取设备和文件系统类型, NodeExpandVolumeRequest 中没有 VolumeContext
+	m := mounts[len(mounts)-1]
+	if err := mount.ResizeFs(m.Source, volumePath, m.FsType); err != nil {
+		return nil, err
+	}
+
+	klog.Infof("volume %s expanded at %s", volumeID, volumePath)
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: capacity}, nil
 }
 
 func (cns *CSINodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
@@ -114,6 +480,7 @@ func (cns *CSINodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoR
 	klog.Info("start NodeGetInfo function")
 
 	return &csi.NodeGetInfoResponse{
-		NodeId: cns.driver.config.NodeID,
+		NodeId:             cns.driver.config.NodeID,
+		AccessibleTopology: &csi.Topology{Segments: cns.driver.nodeTopology()},
 	}, nil
 }
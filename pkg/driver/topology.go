@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// lvm volume 只能在所在 node 上访问, 使用 <driver name>/node 作为拓扑 key, 值为 node id
+func topologyKeyNode(driverName string) string {
+	return driverName + "/node"
+}
+
+// 当前 node 的拓扑信息, 包括 node 以及配置的 zone/rack 等标签
+func (d *CSIDriver) nodeTopology() map[string]string {
+	segments := make(map[string]string, len(d.config.TopologyLabels)+1)
+	for key, value := range d.config.TopologyLabels {
+		segments[key] = value
+	}
+	segments[topologyKeyNode(d.config.DriverName)] = d.config.NodeID
+
+	return segments
+}
+
+// volume 只能在所在的 node 上访问
+func (d *CSIDriver) accessibleTopology() []*csi.Topology {
+	return []*csi.Topology{
+		{
+			Segments: d.nodeTopology(),
+		},
+	}
+}
+
+// topology 是否包含当前 node, topology 为空时视为包含, 不认识的 key 忽略
+func (d *CSIDriver) isAccessibleFrom(topology *csi.Topology) bool {
+	segments := d.nodeTopology()
+	for key, value := range topology.GetSegments() {
+		if v, ok := segments[key]; ok && v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// volume 只能创建在当前 node 上, requisite 不为空时当前 node 必须满足其中之一, 否则返回 ResourceExhausted
+// preferred 只是建议, 不满足时仍然在当前 node 上创建
+func (d *CSIDriver) checkAccessibilityRequirements(requirements *csi.TopologyRequirement) error {
+	if requisite := requirements.GetRequisite(); len(requisite) > 0 {
+		for _, topology := range requisite {
+			if d.isAccessibleFrom(topology) {
+				return nil
+			}
+		}
+		return status.Errorf(codes.ResourceExhausted, "node %s doesn't satisfy any requisite topology", d.config.NodeID)
+	}
+
+	preferred := requirements.GetPreferred()
+	for _, topology := range preferred {
+		if d.isAccessibleFrom(topology) {
+			return nil
+		}
+	}
+	if len(preferred) > 0 {
+		klog.Infof("node %s doesn't satisfy any preferred topology, create volume on it anyway", d.config.NodeID)
+	}
+
+	return nil
+}
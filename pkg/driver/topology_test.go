@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckAccessibilityRequirements(t *testing.T) {
+	d, err := NewCSIDriver(&config.Config{
+		DriverName:     "csidriver.whou.io",
+		EndPoint:       "unix:///csi/csi.sock",
+		NodeID:         "node-1",
+		TopologyLabels: map[string]string{"topology.kubernetes.io/zone": "zone-a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeKey := topologyKeyNode(d.config.DriverName)
+	local := &csi.Topology{Segments: map[string]string{nodeKey: "node-1"}}
+	remote := &csi.Topology{Segments: map[string]string{nodeKey: "node-2"}}
+	otherZone := &csi.Topology{Segments: map[string]string{nodeKey: "node-1", "topology.kubernetes.io/zone": "zone-b"}}
+
+	cases := []struct {
+		requirements *csi.TopologyRequirement
+		code         codes.Code
+	}{
+		{requirements: nil, code: codes.OK},
+		{requirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{remote, local}}, code: codes.OK},
+		{requirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{remote}}, code: codes.ResourceExhausted},
+		{requirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{otherZone}}, code: codes.ResourceExhausted},
+		{requirements: &csi.TopologyRequirement{Preferred: []*csi.Topology{remote}}, code: codes.OK},
+	}
+
+	for i, c := range cases {
+		if err := d.checkAccessibilityRequirements(c.requirements); status.Code(err) != c.code {
+			t.Errorf("case %d: expected %v, got %v", i, c.code, err)
+		}
+	}
+
+	segments := d.nodeTopology()
+	if segments[nodeKey] != "node-1" || segments["topology.kubernetes.io/zone"] != "zone-a" {
+		t.Errorf("unexpected node topology: %v", segments)
+	}
+}
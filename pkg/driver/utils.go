@@ -3,9 +3,18 @@ package driver
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/lvm"
+	"github.com/houwenchen/kubernetes-csi/pkg/mount"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 )
 
@@ -23,9 +32,175 @@ func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h
 	klog.V(2).Infof("GRPC call: %s", info.FullMethod)
 
 	resp, err := handler(ctx, req)
+	err = toStatusError(err)
 	if err != nil {
 		klog.Errorf("GRPC error: %v", err)
 	}
 
 	return resp, err
 }
+
+// VolumeContext 中 node 端需要使用的 key
+const (
+	volumeContextKeyVGName = "vgname"
+)
+
+// 根据 VolumeId 和 VolumeContext 获取 lv 对应的设备路径, 如 /dev/lvmvg/pvc-xxx
+// 旧版本的 VolumeId 中没有 vg, 从 VolumeContext 中获取
+func getDevicePath(volumeDir, volumeID string, volumeContext map[string]string) (string, error) {
+	vgname, name, err := lvm.ParseVolumeID(volumeID)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if len(vgname) == 0 {
+		vgname = volumeContext[volumeContextKeyVGName]
+	}
+	if len(vgname) == 0 {
+		return "", status.Errorf(codes.InvalidArgument, "volume context of volume %s doesn't contain %s", volumeID, volumeContextKeyVGName)
+	}
+
+	return filepath.Join(volumeDir, vgname, name), nil
+}
+
+// 卸载 target 上的所有挂载并删除 target, target 不存在时直接返回
+func unmountAndRemove(target string) error {
+	for {
+		mounted, err := mount.IsMountPoint(target)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			break
+		}
+		if err := mount.Unmount(target); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "remove %s failed: %v", target, err)
+	}
+
+	return nil
+}
+
+// 两个挂载项是否来自同一个设备的同一个目录
+func isSameDevice(a, b mount.MountInfo) bool {
+	return a.Major == b.Major && a.Minor == b.Minor && a.Root == b.Root
+}
+
+// 两个路径是否指向同一个块设备
+func isSameDeviceFile(a, b string) (bool, error) {
+	var statA, statB syscall.Stat_t
+	if err := syscall.Stat(a, &statA); err != nil {
+		return false, status.Errorf(codes.Internal, "stat %s failed: %v", a, err)
+	}
+	if err := syscall.Stat(b, &statB); err != nil {
+		return false, status.Errorf(codes.Internal, "stat %s failed: %v", b, err)
+	}
+	return statA.Rdev == statB.Rdev, nil
+}
+
+// 根据 MaxEntries 和 StartingToken 计算分页范围 [start, end), ids 为按顺序排列的 volume 或者 snapshot id
+// nextToken 为本页最后一条的 id, 下一页从它之后开始, 两次请求之间该条目被删除导致 token 失效时返回 Aborted
+func paginate(ids []string, maxEntries int32, startingToken string) (int, int, string, error) {
+	if maxEntries < 0 {
+		return 0, 0, "", status.Errorf(codes.InvalidArgument, "max entries can't be negative: %d", maxEntries)
+	}
+
+	total := len(ids)
+	start := 0
+	if len(startingToken) > 0 {
+		i := sort.SearchStrings(ids, startingToken)
+		if i >= total || ids[i] != startingToken {
+			return 0, 0, "", status.Errorf(codes.Aborted, "invalid starting token: %s", startingToken)
+		}
+		start = i + 1
+	}
+
+	end := total
+	nextToken := ""
+	if maxEntries > 0 && start+int(maxEntries) < total {
+		end = start + int(maxEntries)
+		nextToken = ids[end-1]
+	}
+
+	return start, end, nextToken, nil
+}
+
+// 将 volume 扩容到 capRange 要求的大小并返回扩容后的大小, lv 已经满足要求时不做操作
+// 调用方需要持有 volume 的锁
+func (d *CSIDriver) extendVolume(volumeID string, capRange *csi.CapacityRange) (int64, error) {
+	lv, err := lvm.GetOwnedVolumeByID(d.config.DriverName, volumeID)
+	if err != nil {
+		return 0, err
+	}
+	if lv == nil {
+		return 0, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+	}
+
+	vg, err := lvm.GetVolumeGroup(lv.VGName)
+	if err != nil {
+		return 0, err
+	}
+	if vg == nil {
+		return 0, status.Errorf(codes.NotFound, "vg %s of volume %s not found", lv.VGName, volumeID)
+	}
+
+	// lvm 按 extent 分配空间, 扩容后的实际大小需要满足 LimitBytes
+	newSize := lvm.RoundUpToExtent(capRange.GetRequiredBytes(), vg.ExtentSize)
+	if limit := capRange.GetLimitBytes(); limit > 0 && newSize > limit {
+		return 0, status.Errorf(codes.OutOfRange, "size %d rounded up to extent exceeds limit %d", newSize, limit)
+	}
+
+	// 上次 lvextend 成功但重新挂上缓存失败时, 重试只需要恢复缓存
+	if err := lvm.ReattachCache(lv); err != nil {
+		return 0, err
+	}
+
+	if lv.Size >= newSize {
+		klog.Infof("volume %s size %d already satisfies requested size %d", volumeID, lv.Size, newSize)
+		return lv.Size, nil
+	}
+
+	if err := lvm.ExtendLogicalVolume(lv.VGName, lv.Name, newSize, d.config.ThinPoolOverprovisionRatio); err != nil {
+		return 0, err
+	}
+	return newSize, nil
+}
+
+// 根据 lv 及其所在 thin pool 的状态生成 VolumeCondition, 供 external-health-monitor 使用
+func (d *CSIDriver) newVolumeCondition(lv *lvm.LVInfo, pools map[string]*lvm.LVInfo) *csi.VolumeCondition {
+	problems := lvm.CheckVolumeHealth(lv)
+	if lv.IsThin() {
+		if pool, ok := pools[lvm.ThinPoolKey(lv.VGName, lv.PoolLV)]; ok {
+			problems = append(problems, lvm.CheckThinPoolHealth(pool, d.config.ThinPoolFillThreshold)...)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("lv %s/%s (attr %s): %s", lv.VGName, lv.Name, lv.Attr, strings.Join(problems, "; ")),
+		}
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  fmt.Sprintf("lv %s/%s (attr %s) is healthy", lv.VGName, lv.Name, lv.Attr),
+	}
+}
+
+// volume path 是否为块设备, block volume 的 volume path 是 bind mount 上来的设备文件
+// volume path 不存在时返回 NotFound
+func isBlockDevicePath(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, status.Errorf(codes.NotFound, "path %s not found", path)
+		}
+		return false, status.Errorf(codes.Internal, "stat %s failed: %v", path, err)
+	}
+	return info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0, nil
+}
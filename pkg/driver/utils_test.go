@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPaginate(t *testing.T) {
+	ids := []string{"lvmvg/pvc-1", "lvmvg/pvc-2", "lvmvg/pvc-3", "lvmvg/pvc-4", "lvmvg/pvc-5"}
+	cases := []struct {
+		ids           []string
+		maxEntries    int32
+		startingToken string
+		start, end    int
+		nextToken     string
+	}{
+		{ids: ids, maxEntries: 0, start: 0, end: 5},
+		{ids: ids, maxEntries: 2, start: 0, end: 2, nextToken: "lvmvg/pvc-2"},
+		{ids: ids, maxEntries: 2, startingToken: "lvmvg/pvc-2", start: 2, end: 4, nextToken: "lvmvg/pvc-4"},
+		{ids: ids, maxEntries: 2, startingToken: "lvmvg/pvc-4", start: 4, end: 5},
+		{ids: ids, maxEntries: 5, start: 0, end: 5},
+		{ids: nil, maxEntries: 2, start: 0, end: 0},
+	}
+
+	for _, c := range cases {
+		start, end, nextToken, err := paginate(c.ids, c.maxEntries, c.startingToken)
+		if err != nil {
+			t.Errorf("paginate(%v, %d, %q) failed: %v", c.ids, c.maxEntries, c.startingToken, err)
+			continue
+		}
+		if start != c.start || end != c.end || nextToken != c.nextToken {
+			t.Errorf("paginate(%v, %d, %q) = %d, %d, %q", c.ids, c.maxEntries, c.startingToken, start, end, nextToken)
+		}
+	}
+}
+
+func TestPaginateChangedList(t *testing.T) {
+	// 第一页返回 pvc-1, pvc-2 后 pvc-1 被删除, pvc-0 被创建, 下一页仍然从 pvc-2 之后开始
+	ids := []string{"lvmvg/pvc-0", "lvmvg/pvc-2", "lvmvg/pvc-3", "lvmvg/pvc-4"}
+	start, end, nextToken, err := paginate(ids, 2, "lvmvg/pvc-2")
+	if err != nil || start != 2 || end != 4 || nextToken != "" {
+		t.Errorf("paginate after change = %d, %d, %q, %v", start, end, nextToken, err)
+	}
+}
+
+func TestPaginateInvalid(t *testing.T) {
+	// token 对应的 volume 被删除后 token 失效
+	ids := []string{"lvmvg/pvc-1", "lvmvg/pvc-3", "lvmvg/pvc-4"}
+	for _, token := range []string{"lvmvg/pvc-2", "lvmvg/pvc-5", "abc"} {
+		_, _, _, err := paginate(ids, 2, token)
+		if status.Code(err) != codes.Aborted {
+			t.Errorf("expected Aborted for token %q, got %v", token, err)
+		}
+	}
+
+	if _, _, _, err := paginate(ids, -1, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for negative max entries, got %v", err)
+	}
+}
+
+func TestGetDevicePath(t *testing.T) {
+	cases := []struct {
+		volumeID      string
+		volumeContext map[string]string
+		want          string
+	}{
+		{volumeID: "lvmvg/pvc-1", want: "/dev/lvmvg/pvc-1"},
+		{volumeID: "lvmvg/pvc-1", volumeContext: map[string]string{volumeContextKeyVGName: "other"}, want: "/dev/lvmvg/pvc-1"},
+		// 旧版本的 volume id 只有 lv 名称
+		{volumeID: "pvc-1", volumeContext: map[string]string{volumeContextKeyVGName: "lvmvg"}, want: "/dev/lvmvg/pvc-1"},
+	}
+	for _, c := range cases {
+		path, err := getDevicePath("/dev", c.volumeID, c.volumeContext)
+		if err != nil || path != c.want {
+			t.Errorf("getDevicePath(%s, %v) = %s, %v", c.volumeID, c.volumeContext, path, err)
+		}
+	}
+
+	if _, err := getDevicePath("/dev", "pvc-1", nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument without vgname, got %v", err)
+	}
+}
+
+func TestIsBlockDevicePath(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{dir, file} {
+		isBlock, err := isBlockDevicePath(path)
+		if err != nil {
+			t.Errorf("isBlockDevicePath(%s) failed: %v", path, err)
+		} else if isBlock {
+			t.Errorf("isBlockDevicePath(%s) = true, expected false", path)
+		}
+	}
+
+	if _, err := isBlockDevicePath(filepath.Join(dir, "missing")); status.Code(err) != codes.NotFound {
+		t.Errorf("isBlockDevicePath of a missing path returned %v, expected NotFound", err)
+	}
+}
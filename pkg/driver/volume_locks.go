@@ -0,0 +1,42 @@
+package driver
+
+import (
+	"sync"
+)
+
+// 同一个 volume 上已有操作在进行时返回的错误信息
+const volumeOperationAlreadyExistsFmt = "an operation with the given volume %s already exists"
+
+// VolumeLocks 记录正在进行操作的 volume, controller 和 node 共用同一个实例
+// 同一个 volume 上的并发请求直接拒绝, 由 sidecar 稍后重试, 不阻塞等待
+type VolumeLocks struct {
+	mu    sync.Mutex
+	locks map[string]struct{}
+}
+
+func NewVolumeLocks() *VolumeLocks {
+	return &VolumeLocks{
+		locks: make(map[string]struct{}),
+	}
+}
+
+// TryAcquire 尝试获取 volume 的锁, 已被占用时返回 false
+func (vl *VolumeLocks) TryAcquire(volumeID string) bool {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+
+	if _, exists := vl.locks[volumeID]; exists {
+		return false
+	}
+	vl.locks[volumeID] = struct{}{}
+
+	return true
+}
+
+// Release 释放 volume 的锁
+func (vl *VolumeLocks) Release(volumeID string) {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+
+	delete(vl.locks, volumeID)
+}
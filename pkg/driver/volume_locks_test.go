@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeLocks(t *testing.T) {
+	vl := NewVolumeLocks()
+
+	if !vl.TryAcquire("pvc-1") {
+		t.Fatal("expected to acquire lock of pvc-1")
+	}
+	if vl.TryAcquire("pvc-1") {
+		t.Fatal("expected lock of pvc-1 to be held")
+	}
+	if !vl.TryAcquire("pvc-2") {
+		t.Fatal("expected to acquire lock of pvc-2 while pvc-1 is held")
+	}
+
+	vl.Release("pvc-1")
+	if !vl.TryAcquire("pvc-1") {
+		t.Fatal("expected to acquire lock of pvc-1 after release")
+	}
+
+	// 释放不存在的锁不会出错
+	vl.Release("pvc-3")
+}
+
+func TestVolumeLocksConcurrent(t *testing.T) {
+	vl := NewVolumeLocks()
+
+	const workers = 50
+	var (
+		wg       sync.WaitGroup
+		acquired int32
+		inflight int32
+		start    = make(chan struct{})
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			if !vl.TryAcquire("pvc-1") {
+				return
+			}
+			defer vl.Release("pvc-1")
+
+			atomic.AddInt32(&acquired, 1)
+			if n := atomic.AddInt32(&inflight, 1); n != 1 {
+				t.Errorf("expected exactly 1 operation in flight, got %d", n)
+			}
+			atomic.AddInt32(&inflight, -1)
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	if acquired == 0 {
+		t.Fatal("expected at least one worker to acquire the lock")
+	}
+	if !vl.TryAcquire("pvc-1") {
+		t.Fatal("expected lock of pvc-1 to be released by all workers")
+	}
+}
+
+func TestVolumeLocksDifferentVolumes(t *testing.T) {
+	vl := NewVolumeLocks()
+
+	var wg sync.WaitGroup
+	ids := []string{"pvc-1", "pvc-2", "pvc-3", "pvc-4"}
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if !vl.TryAcquire(id) {
+				t.Errorf("expected to acquire lock of %s", id)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if vl.TryAcquire(id) {
+			t.Errorf("expected lock of %s to be held", id)
+		}
+	}
+}
+
+func TestServersRejectConcurrentOperation(t *testing.T) {
+	d, err := NewCSIDriver(&config.Config{
+		DriverName: "csidriver.whou.io",
+		EndPoint:   "unix:///csi/csi.sock",
+		NodeID:     "node-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewDefaultCSIControllerServer(d)
+	ns := NewDefaultCSINodeServer(d)
+
+	// 模拟 pvc-1 上已有操作在进行
+	if !d.volumeLocks.TryAcquire("pvc-1") {
+		t.Fatal("expected to acquire lock of pvc-1")
+	}
+	defer d.volumeLocks.Release("pvc-1")
+
+	_, err = cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "pvc-1"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("DeleteVolume: expected Aborted, got %v", err)
+	}
+
+	_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "pvc-1",
+		TargetPath: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-1/mount",
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("NodeUnpublishVolume: expected Aborted, got %v", err)
+	}
+}
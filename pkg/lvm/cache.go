@@ -0,0 +1,368 @@
+package lvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+/*
+使用 vg 中的快速设备 (例如 nvme) 为 lv 添加缓存
+1. 在快速设备上创建缓存 lv
+root@master:~# lvcreate -n pvc-xxx-cache -L 1073741824b lvmvg /dev/nvme0n1
+2. 将缓存 lv 挂到 lv 上, dm-cache 支持 writethrough 和 writeback, dm-writecache 只缓存写入
+root@master:~# lvconvert -y --type cache --cachevol pvc-xxx-cache --cachemode writethrough lvmvg/pvc-xxx
+root@master:~# lvconvert -y --type writecache --cachevol pvc-xxx-cache lvmvg/pvc-xxx
+3. 删除前刷回脏数据并删除缓存 lv
+root@master:~# lvconvert -y --uncache lvmvg/pvc-xxx
+4. 扩容前刷回脏数据并拆下缓存 lv, 扩容后重新挂上
+root@master:~# lvconvert -y --splitcache lvmvg/pvc-xxx
+*/
+
+// StorageClass 中缓存相关的参数, 同时作为 VolumeContext 的 key
+const (
+	// 缓存 lv 所在的 pv, 例如 /dev/nvme0n1, 也可以使用 @tag 指定带有该 tag 的 pv
+	CachePoolParam = "cachepool"
+	CacheModeParam = "cachemode"
+	CacheTypeParam = "cachetype"
+	// 缓存 lv 占 lv 大小的百分比
+	CacheSizePercentParam = "cachesizepercent"
+)
+
+// 缓存模式和类型
+const (
+	CacheModeWritethrough = "writethrough"
+	CacheModeWriteback    = "writeback"
+
+	// dm-cache, 同时缓存读写
+	CacheTypeCache = "cache"
+	// dm-writecache, 只缓存写入, 总是 writeback
+	CacheTypeWritecache = "writecache"
+)
+
+const defaultCacheSizePercent = 10
+
+// 缓存 lv 的名称为 <lv>-cache
+const cacheVolumeSuffix = "-cache"
+
+// 创建时记录到 lv 的 tag 中的缓存参数, 扩容后重新挂载缓存时使用
+var cacheKeys = []string{CachePoolParam, CacheModeParam, CacheTypeParam, CacheSizePercentParam}
+
+// Cache 为 lv 的缓存配置
+type Cache struct {
+	PV          string
+	Mode        string
+	Type        string
+	SizePercent int
+}
+
+// ParseCache 解析并校验参数中的缓存配置, 没有指定 cachepool 时返回 nil
+func ParseCache(paras map[string]string) (*Cache, error) {
+	pv, ok := paras[CachePoolParam]
+	if !ok {
+		for _, key := range cacheKeys {
+			if _, ok := paras[key]; ok {
+				return nil, fmt.Errorf("%w: %s requires %s", ErrInvalidArgument, key, CachePoolParam)
+			}
+		}
+		return nil, nil
+	}
+	if len(pv) == 0 {
+		return nil, fmt.Errorf("%w: %s can't be empty", ErrInvalidArgument, CachePoolParam)
+	}
+
+	cache := &Cache{
+		PV:          pv,
+		Mode:        paras[CacheModeParam],
+		Type:        paras[CacheTypeParam],
+		SizePercent: defaultCacheSizePercent,
+	}
+
+	if len(cache.Type) == 0 {
+		cache.Type = CacheTypeCache
+	}
+	switch cache.Type {
+	case CacheTypeCache:
+		if len(cache.Mode) == 0 {
+			cache.Mode = CacheModeWritethrough
+		}
+		if cache.Mode != CacheModeWritethrough && cache.Mode != CacheModeWriteback {
+			return nil, fmt.Errorf("%w: unsupported %s %q", ErrInvalidArgument, CacheModeParam, cache.Mode)
+		}
+	case CacheTypeWritecache:
+		if len(cache.Mode) > 0 && cache.Mode != CacheModeWriteback {
+			return nil, fmt.Errorf("%w: %s only supports %s %s", ErrInvalidArgument, CacheTypeWritecache, CacheModeParam, CacheModeWriteback)
+		}
+		cache.Mode = CacheModeWriteback
+	default:
+		return nil, fmt.Errorf("%w: unsupported %s %q", ErrInvalidArgument, CacheTypeParam, cache.Type)
+	}
+
+	if v, ok := paras[CacheSizePercentParam]; ok {
+		p, err := strconv.Atoi(v)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("%w: invalid %s %q", ErrInvalidArgument, CacheSizePercentParam, v)
+		}
+		cache.SizePercent = p
+	}
+
+	return cache, nil
+}
+
+// Tags 返回需要添加到 lv 上记录缓存配置的 tag
+func (c *Cache) Tags() []string {
+	if c == nil {
+		return nil
+	}
+	return []string{
+		paramTag(CachePoolParam, c.PV),
+		paramTag(CacheModeParam, c.Mode),
+		paramTag(CacheTypeParam, c.Type),
+		paramTag(CacheSizePercentParam, strconv.Itoa(c.SizePercent)),
+	}
+}
+
+// VolumeContext 返回需要记录到 VolumeContext 中的缓存配置, 没有缓存时返回空
+func (c *Cache) VolumeContext() map[string]string {
+	ctx := make(map[string]string)
+	if c == nil {
+		return ctx
+	}
+
+	ctx[CachePoolParam] = c.PV
+	ctx[CacheModeParam] = c.Mode
+	ctx[CacheTypeParam] = c.Type
+	return ctx
+}
+
+// CacheContext 从 lv 的 tag 中读取创建时记录的缓存配置, 没有缓存时返回空
+func (lv *LVInfo) CacheContext() map[string]string {
+	return lv.Cache().VolumeContext()
+}
+
+// Cache 从 lv 的 tag 中读取创建时记录的缓存配置, 没有缓存时返回 nil
+func (lv *LVInfo) Cache() *Cache {
+	pv, ok := lv.paramTagValue(CachePoolParam)
+	if !ok {
+		return nil
+	}
+
+	cache := &Cache{PV: pv, SizePercent: defaultCacheSizePercent}
+	cache.Mode, _ = lv.paramTagValue(CacheModeParam)
+	cache.Type, _ = lv.paramTagValue(CacheTypeParam)
+	if v, ok := lv.paramTagValue(CacheSizePercentParam); ok {
+		if p, err := strconv.Atoi(v); err == nil {
+			cache.SizePercent = p
+		}
+	}
+	return cache
+}
+
+// MatchCache 已存在的 lv 的缓存配置是否与参数中的一致
+func MatchCache(paras map[string]string, lv *LVInfo) (bool, error) {
+	cache, err := ParseCache(paras)
+	if err != nil {
+		return false, err
+	}
+
+	return equalContext(cache.VolumeContext(), lv.CacheContext()), nil
+}
+
+func cacheVolumeName(name string) string {
+	return name + cacheVolumeSuffix
+}
+
+// 在快速设备上创建缓存 lv 并挂到 lv 上, 扩容时拆下的缓存 lv 直接复用
+func attachCache(vgname, name string, size int64, cache *Cache) error {
+	cacheName := cacheVolumeName(name)
+
+	existing, err := GetLogicalVolume(vgname, cacheName)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		cacheSize := size * int64(cache.SizePercent) / 100
+		out, err := runCommand(lvCreate, "-n", cacheName, "-L", fmt.Sprintf("%db", cacheSize), vgname, cache.PV)
+		if err != nil {
+			klog.Infof("create cache lv failed, lvname: %s, vgname: %s, pv: %s\n", cacheName, vgname, cache.PV)
+			return err
+		}
+		klog.Info(string(out))
+	}
+
+	convertArg := []string{"-y", "--type", cache.Type, "--cachevol", cacheName}
+	if cache.Type == CacheTypeCache {
+		convertArg = append(convertArg, "--cachemode", cache.Mode)
+	}
+	convertArg = append(convertArg, vgname+"/"+name)
+
+	out, err := runCommand(lvConvert, convertArg...)
+	if err != nil {
+		klog.Infof("attach cache failed, lvname: %s, vgname: %s, cache: %s\n", name, vgname, cacheName)
+		return err
+	}
+
+	klog.Info(string(out))
+	return nil
+}
+
+// 刷回脏数据后拆下缓存, keep 为 true 时保留缓存 lv 以便重新挂载, 否则删除缓存 lv
+func detachCache(vgname, name string, keep bool) error {
+	action := "--uncache"
+	if keep {
+		action = "--splitcache"
+	}
+
+	klog.Infof("flushing and detaching cache of lv %s/%s", vgname, name)
+	out, err := runCommand(lvConvert, "-y", action, vgname+"/"+name)
+	if err != nil {
+		klog.Infof("detach cache failed, lvname: %s, vgname: %s\n", name, vgname)
+		return err
+	}
+
+	klog.Info(string(out))
+	return nil
+}
+
+// NeedsCacheReattach lv 创建时配置了缓存但当前没有挂上, 例如扩容后重新挂载缓存失败
+func (lv *LVInfo) NeedsCacheReattach() bool {
+	return lv.Cache() != nil && !lv.IsCached()
+}
+
+// ReattachCache 按照 lv tag 中记录的配置重新挂上缓存, 已经挂上缓存时不做操作
+func ReattachCache(lv *LVInfo) error {
+	if !lv.NeedsCacheReattach() {
+		return nil
+	}
+
+	klog.Infof("reattaching cache to lv %s/%s", lv.VGName, lv.Name)
+	return attachCache(lv.VGName, lv.Name, lv.Size, lv.Cache())
+}
+
+// 删除扩容失败等情况下遗留的缓存 lv
+func removeDetachedCache(vgname, name string) error {
+	cacheName := cacheVolumeName(name)
+
+	existing, err := GetLogicalVolume(vgname, cacheName)
+	if err != nil || existing == nil {
+		return err
+	}
+
+	klog.Infof("removing detached cache lv %s/%s", vgname, cacheName)
+	_, err = runCommand(lvRemove, "-f", vgname+"/"+cacheName)
+	return err
+}
+
+// CacheStats 为缓存的命中统计, 单位为 block
+type CacheStats struct {
+	Type string
+
+	// dm-cache
+	ReadHits    int64
+	ReadMisses  int64
+	WriteHits   int64
+	WriteMisses int64
+	DirtyBlocks int64
+	UsedBlocks  int64
+	TotalBlocks int64
+
+	// dm-writecache
+	FreeBlocks      int64
+	WritebackBlocks int64
+}
+
+var cacheStatsFields = []string{
+	"cache_total_blocks",
+	"cache_used_blocks",
+	"cache_dirty_blocks",
+	"cache_read_hits",
+	"cache_read_misses",
+	"cache_write_hits",
+	"cache_write_misses",
+}
+
+var writecacheStatsFields = []string{
+	"writecache_total_blocks",
+	"writecache_free_blocks",
+	"writecache_writeback_blocks",
+}
+
+// GetCacheStats 查询 lv 缓存的命中统计
+// lvs --reportformat json -o cache_read_hits,cache_read_misses,... lvmvg/pvc-xxx
+func GetCacheStats(lv *LVInfo) (*CacheStats, error) {
+	cacheType := CacheTypeCache
+	if cache := lv.Cache(); cache != nil && len(cache.Type) > 0 {
+		cacheType = cache.Type
+	}
+
+	fields := cacheStatsFields
+	if cacheType == CacheTypeWritecache {
+		fields = writecacheStatsFields
+	}
+
+	out, err := runReportCommand(lvs, "--reportformat", "json", "-o", strings.Join(fields, ","), lv.VGName+"/"+lv.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCacheStatsReport(out, cacheType)
+}
+
+func parseCacheStatsReport(out []byte, cacheType string) (*CacheStats, error) {
+	var report lvsReport
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("decode lvs report failed: %v", err)
+	}
+	if len(report.Report) == 0 || len(report.Report[0].LV) == 0 {
+		return nil, fmt.Errorf("no cache stats in lvs report")
+	}
+
+	fields := report.Report[0].LV[0]
+	stats := &CacheStats{Type: cacheType}
+	for key, value := range map[string]*int64{
+		"cache_total_blocks":          &stats.TotalBlocks,
+		"cache_used_blocks":           &stats.UsedBlocks,
+		"cache_dirty_blocks":          &stats.DirtyBlocks,
+		"cache_read_hits":             &stats.ReadHits,
+		"cache_read_misses":           &stats.ReadMisses,
+		"cache_write_hits":            &stats.WriteHits,
+		"cache_write_misses":          &stats.WriteMisses,
+		"writecache_total_blocks":     &stats.TotalBlocks,
+		"writecache_free_blocks":      &stats.FreeBlocks,
+		"writecache_writeback_blocks": &stats.WritebackBlocks,
+	} {
+		v, ok := fields[key]
+		if !ok {
+			continue
+		}
+		n, err := parseSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s failed: %v", key, err)
+		}
+		*value = n
+	}
+
+	return stats, nil
+}
+
+func (s *CacheStats) String() string {
+	if s.Type == CacheTypeWritecache {
+		return fmt.Sprintf("writecache used %d/%d blocks, %d blocks under writeback",
+			s.TotalBlocks-s.FreeBlocks, s.TotalBlocks, s.WritebackBlocks)
+	}
+
+	return fmt.Sprintf("cache read hits %d/%d (%s), write hits %d/%d (%s), used %d/%d blocks, %d dirty",
+		s.ReadHits, s.ReadHits+s.ReadMisses, hitRatio(s.ReadHits, s.ReadMisses),
+		s.WriteHits, s.WriteHits+s.WriteMisses, hitRatio(s.WriteHits, s.WriteMisses),
+		s.UsedBlocks, s.TotalBlocks, s.DirtyBlocks)
+}
+
+func hitRatio(hits, misses int64) string {
+	if hits+misses == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", float64(hits)*100/float64(hits+misses))
+}
@@ -0,0 +1,124 @@
+package lvm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseCache(t *testing.T) {
+	cache, err := ParseCache(map[string]string{})
+	if err != nil || cache != nil {
+		t.Errorf("expected no cache without %s, got %+v, %v", CachePoolParam, cache, err)
+	}
+
+	cases := []struct {
+		paras map[string]string
+		want  Cache
+	}{
+		{
+			paras: map[string]string{CachePoolParam: "/dev/nvme0n1"},
+			want:  Cache{PV: "/dev/nvme0n1", Mode: CacheModeWritethrough, Type: CacheTypeCache, SizePercent: 10},
+		},
+		{
+			paras: map[string]string{CachePoolParam: "@fast", CacheModeParam: CacheModeWriteback, CacheSizePercentParam: "20"},
+			want:  Cache{PV: "@fast", Mode: CacheModeWriteback, Type: CacheTypeCache, SizePercent: 20},
+		},
+		{
+			paras: map[string]string{CachePoolParam: "/dev/nvme0n1", CacheTypeParam: CacheTypeWritecache},
+			want:  Cache{PV: "/dev/nvme0n1", Mode: CacheModeWriteback, Type: CacheTypeWritecache, SizePercent: 10},
+		},
+	}
+	for _, c := range cases {
+		cache, err := ParseCache(c.paras)
+		if err != nil {
+			t.Errorf("parse cache %v failed: %v", c.paras, err)
+			continue
+		}
+		if *cache != c.want {
+			t.Errorf("parse cache %v: expected %+v, got %+v", c.paras, c.want, *cache)
+		}
+	}
+
+	for _, paras := range []map[string]string{
+		{CacheModeParam: CacheModeWriteback},
+		{CachePoolParam: ""},
+		{CachePoolParam: "/dev/nvme0n1", CacheModeParam: "writearound"},
+		{CachePoolParam: "/dev/nvme0n1", CacheTypeParam: "dm-cache"},
+		{CachePoolParam: "/dev/nvme0n1", CacheTypeParam: CacheTypeWritecache, CacheModeParam: CacheModeWritethrough},
+		{CachePoolParam: "/dev/nvme0n1", CacheSizePercentParam: "0"},
+	} {
+		if _, err := ParseCache(paras); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("expected ErrInvalidArgument for %v, got %v", paras, err)
+		}
+	}
+}
+
+func TestCacheTags(t *testing.T) {
+	paras := map[string]string{CachePoolParam: "/dev/nvme0n1", CacheModeParam: CacheModeWriteback, CacheSizePercentParam: "20"}
+	cache, err := ParseCache(paras)
+	if err != nil {
+		t.Fatalf("parse cache failed: %v", err)
+	}
+
+	// 挂了缓存的 lv 的 pool_lv 为隐藏的缓存 lv, 不是 thin lv
+	lv := &LVInfo{Name: "pvc-1", VGName: "lvmvg", Attr: "Cwi-aoC---", PoolLV: "[pvc-1-cache_cvol]", Tags: cache.Tags()}
+	if !lv.IsCached() || lv.IsThin() {
+		t.Errorf("lv %s should be cached and not thin", lv.Name)
+	}
+	if got := lv.Cache(); got == nil || *got != *cache {
+		t.Errorf("expected cache %+v from tags, got %+v", cache, got)
+	}
+
+	if matched, err := MatchCache(paras, lv); err != nil || !matched {
+		t.Errorf("expected cache to match, got %v, %v", matched, err)
+	}
+	if matched, err := MatchCache(map[string]string{}, lv); err != nil || matched {
+		t.Errorf("expected volume without cache not to match, got %v, %v", matched, err)
+	}
+
+	// 缓存被拆下后需要上报异常
+	lv.Attr = "-wi-ao----"
+	if problems := CheckVolumeHealth(lv); !reflect.DeepEqual(problems, []string{"cache is not attached"}) {
+		t.Errorf("unexpected problems of detached cache: %v", problems)
+	}
+	// 扩容后挂载缓存失败的 lv 重试时需要重新挂上缓存
+	if !lv.NeedsCacheReattach() {
+		t.Errorf("lv %s with detached cache should need reattach", lv.Name)
+	}
+	if lv = (&LVInfo{Name: "pvc-2", VGName: "lvmvg", Attr: "-wi-ao----"}); lv.NeedsCacheReattach() {
+		t.Errorf("lv %s without cache should not need reattach", lv.Name)
+	}
+}
+
+func TestParseCacheStatsReport(t *testing.T) {
+	report := `{
+      "report": [
+          {
+              "lv": [
+                  {"cache_total_blocks":"1000", "cache_used_blocks":"400", "cache_dirty_blocks":"10", "cache_read_hits":"300", "cache_read_misses":"100", "cache_write_hits":"50", "cache_write_misses":"0"}
+              ]
+          }
+      ]
+  }`
+
+	stats, err := parseCacheStatsReport([]byte(report), CacheTypeCache)
+	if err != nil {
+		t.Fatalf("parse cache stats failed: %v", err)
+	}
+	want := CacheStats{Type: CacheTypeCache, ReadHits: 300, ReadMisses: 100, WriteHits: 50, DirtyBlocks: 10, UsedBlocks: 400, TotalBlocks: 1000}
+	if *stats != want {
+		t.Errorf("expected %+v, got %+v", want, *stats)
+	}
+	if msg := stats.String(); msg != "cache read hits 300/400 (75.0%), write hits 50/50 (100.0%), used 400/1000 blocks, 10 dirty" {
+		t.Errorf("unexpected cache stats message: %s", msg)
+	}
+
+	report = `{"report": [{"lv": [{"writecache_total_blocks":"100", "writecache_free_blocks":"60", "writecache_writeback_blocks":"5"}]}]}`
+	if stats, err = parseCacheStatsReport([]byte(report), CacheTypeWritecache); err != nil {
+		t.Fatalf("parse writecache stats failed: %v", err)
+	}
+	if msg := stats.String(); msg != "writecache used 40/100 blocks, 5 blocks under writeback" {
+		t.Errorf("unexpected writecache stats message: %s", msg)
+	}
+}
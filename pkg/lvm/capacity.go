@@ -0,0 +1,176 @@
+package lvm
+
+import (
+	"fmt"
+)
+
+// StorageClass 中指定 vg 和 thin pool 的参数
+const (
+	VGNameParam   = "vgname"
+	ThinPoolParam = "thinpool"
+)
+
+// Capacity 为 vg 或者 thin pool 的可用容量, 单位为 byte
+type Capacity struct {
+	Available int64
+	// 单个 lv 能分配到的最大容量
+	MaximumVolumeSize int64
+}
+
+// GetVGCapacity 统计参数对应的 StorageClass 能够使用的 vg 的空闲容量
+// 与 CreateVolume 选择 vg 时一样按 vgname 或者 vgpattern 过滤, 布局需要多个 pv 时排除 pv 不足的 vg, 都没有指定时统计所有 vg
+// 线性 lv 的最大可分配容量为 pv 上最大的一段连续空闲空间, 其他布局按单个 vg 中折算后的空闲空间计算
+func GetVGCapacity(paras map[string]string) (*Capacity, error) {
+	layout, err := ParseLayout(paras)
+	if err != nil {
+		return nil, err
+	}
+
+	vgInfos, err := ListVolumeGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	matched, err := filterVolumeGroups(vgInfos, paras, layout.RequiredPVs())
+	if err != nil {
+		return nil, err
+	}
+
+	if !layout.IsLinear() {
+		return calcLayoutCapacity(matched, layout), nil
+	}
+
+	segments, err := ListPVSegments(paras[VGNameParam])
+	if err != nil {
+		return nil, err
+	}
+
+	return calcVGCapacity(matched, segments), nil
+}
+
+// 返回参数能够使用的 vg, 没有 vg 满足 vgname 或者 vgpattern 时返回 ErrVGNotFound
+func filterVolumeGroups(vgInfos []*VGInfo, paras map[string]string, minPVs int) ([]*VGInfo, error) {
+	if policy, ok := paras[VGPolicyParam]; ok {
+		switch policy {
+		case VGPolicyBinpack, VGPolicySpread, VGPolicyMostFree:
+		default:
+			return nil, fmt.Errorf("%w: unknown %s %q", ErrInvalidArgument, VGPolicyParam, policy)
+		}
+	}
+
+	var matched []*VGInfo
+	for _, vg := range vgInfos {
+		ok, err := MatchVolumeGroup(paras, vg.Name)
+		if err != nil {
+			return nil, err
+		}
+		// 条带和镜像需要分布在不同的 pv 上
+		if ok && vg.PVCount >= int64(minPVs) {
+			matched = append(matched, vg)
+		}
+	}
+
+	_, hasName := paras[VGNameParam]
+	_, hasPattern := paras[VGPatternParam]
+	if len(matched) == 0 && (hasName || hasPattern) {
+		return nil, fmt.Errorf("%w: no vg with at least %d pvs matches parameters %v", ErrVGNotFound, minPVs, paras)
+	}
+	return matched, nil
+}
+
+// 条带、镜像和 raid 只能分配在一个 vg 中, 可用容量按数据盘占 pv 数量的比例折算, 例如 raid1 为空闲空间的一半
+func calcLayoutCapacity(vgInfos []*VGInfo, layout *Layout) *Capacity {
+	capacity := &Capacity{}
+
+	for _, vg := range vgInfos {
+		usable := layout.UsableSpace(vg.Free)
+		capacity.Available += usable
+		if usable > capacity.MaximumVolumeSize {
+			capacity.MaximumVolumeSize = usable
+		}
+	}
+
+	return capacity
+}
+
+func calcVGCapacity(vgInfos []*VGInfo, segments []*PVSegment) *Capacity {
+	capacity := &Capacity{}
+
+	extentSizes := make(map[string]int64, len(vgInfos))
+	for _, vg := range vgInfos {
+		capacity.Available += vg.Free
+		extentSizes[vg.Name] = vg.ExtentSize
+	}
+
+	for _, seg := range segments {
+		// 不属于任何 vg 的 pv 不能分配
+		extentSize, ok := extentSizes[seg.VGName]
+		if !ok || !seg.IsFree() {
+			continue
+		}
+		if size := seg.Size * extentSize; size > capacity.MaximumVolumeSize {
+			capacity.MaximumVolumeSize = size
+		}
+	}
+
+	return capacity
+}
+
+// GetThinPoolCapacity 统计 thin pool 的可用容量
+// 限制超配时返回剩余的虚拟容量, 否则返回未使用的数据空间
+func GetThinPoolCapacity(vgname, pool string, ratio float64) (*Capacity, error) {
+	lvInfos, err := ListLogicalVolumes(vgname)
+	if err != nil {
+		return nil, err
+	}
+
+	poolInfo := findThinPool(lvInfos, pool)
+	if poolInfo == nil {
+		return nil, fmt.Errorf("%w: thin pool %s/%s", ErrLVNotFound, vgname, pool)
+	}
+
+	return calcThinPoolCapacity(poolInfo, thinPoolVirtualSize(lvInfos, poolInfo), ratio), nil
+}
+
+func calcThinPoolCapacity(pool *LVInfo, used int64, ratio float64) *Capacity {
+	var free int64
+	if ratio > 0 {
+		free = int64(float64(pool.Size)*ratio) - used
+	} else {
+		free = int64(float64(pool.Size) * (100 - pool.DataPercent) / 100)
+	}
+	if free < 0 {
+		free = 0
+	}
+
+	return &Capacity{
+		Available:         free,
+		MaximumVolumeSize: free,
+	}
+}
+
+// EstimateThinPoolCapacity 估算允许自动创建但还不存在的 thin pool 的容量, 不允许自动创建时返回 ErrLVNotFound
+func EstimateThinPoolCapacity(vgname, pool string, paras map[string]string, ratio float64) (*Capacity, error) {
+	autoCreate, sizePercent, err := parseThinPoolAutoCreate(paras)
+	if err != nil {
+		return nil, err
+	}
+	if !autoCreate {
+		return nil, fmt.Errorf("%w: thin pool %s/%s", ErrLVNotFound, vgname, pool)
+	}
+
+	vg, err := GetVolumeGroup(vgname)
+	if err != nil {
+		return nil, err
+	}
+	if vg == nil {
+		return nil, fmt.Errorf("%w: %s", ErrVGNotFound, vgname)
+	}
+
+	poolInfo := &LVInfo{
+		Name:   pool,
+		VGName: vgname,
+		Size:   vg.Free * int64(sizePercent) / 100,
+	}
+	return calcThinPoolCapacity(poolInfo, 0, ratio), nil
+}
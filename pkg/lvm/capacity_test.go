@@ -0,0 +1,133 @@
+package lvm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const testPVSegmentsReport = `{
+      "report": [
+          {
+              "pvseg": [
+                  {"pv_name":"/dev/loop10", "vg_name":"lvmvg", "pvseg_start":"0", "pvseg_size":"1024", "lv_name":"pvc-1"},
+                  {"pv_name":"/dev/loop10", "vg_name":"lvmvg", "pvseg_start":"1024", "pvseg_size":"512", "lv_name":""},
+                  {"pv_name":"/dev/loop11", "vg_name":"lvmvg", "pvseg_start":"0", "pvseg_size":"768", "lv_name":""},
+                  {"pv_name":"/dev/loop12", "vg_name":"", "pvseg_start":"0", "pvseg_size":"4096", "lv_name":""}
+              ]
+          }
+      ]
+  }`
+
+func TestCalcVGCapacity(t *testing.T) {
+	segments, err := parsePVSegmentsReport([]byte(testPVSegmentsReport))
+	if err != nil {
+		t.Fatalf("parse pvs report failed: %v", err)
+	}
+	if len(segments) != 4 {
+		t.Fatalf("expected 4 segments, got %d", len(segments))
+	}
+
+	extentSize := int64(4 * 1024 * 1024)
+	vgInfos := []*VGInfo{{Name: "lvmvg", Free: 1280 * extentSize, ExtentSize: extentSize}}
+
+	capacity := calcVGCapacity(vgInfos, segments)
+	if capacity.Available != 1280*extentSize {
+		t.Errorf("unexpected available capacity: %d", capacity.Available)
+	}
+	// 不属于 vg 的 /dev/loop12 不能分配
+	if capacity.MaximumVolumeSize != 768*extentSize {
+		t.Errorf("unexpected maximum volume size: %d", capacity.MaximumVolumeSize)
+	}
+}
+
+func TestCalcThinPoolCapacity(t *testing.T) {
+	pool := &LVInfo{Name: "pool", Attr: "twi-aotz--", Size: 1000, DataPercent: 25}
+	if !pool.IsThinPool() {
+		t.Fatalf("lv %s should be a thin pool", pool.Name)
+	}
+
+	// 不限制超配时为未使用的数据空间
+	capacity := calcThinPoolCapacity(pool, 1200, 0)
+	if capacity.Available != 750 || capacity.MaximumVolumeSize != 750 {
+		t.Errorf("unexpected thin pool capacity: %+v", capacity)
+	}
+
+	// 限制超配时为剩余的虚拟容量
+	capacity = calcThinPoolCapacity(pool, 1200, 2)
+	if capacity.Available != 800 || capacity.MaximumVolumeSize != 800 {
+		t.Errorf("unexpected thin pool virtual capacity: %+v", capacity)
+	}
+	if capacity = calcThinPoolCapacity(pool, 2500, 2); capacity.Available != 0 {
+		t.Errorf("expected no capacity when pool is overprovisioned, got %+v", capacity)
+	}
+}
+
+func TestFilterVolumeGroups(t *testing.T) {
+	vgInfos := []*VGInfo{
+		{Name: "data-a", Free: 100, PVCount: 1},
+		{Name: "data-b", Free: 300, PVCount: 2},
+		{Name: "system", Free: 1000, PVCount: 4},
+	}
+
+	cases := []struct {
+		paras  map[string]string
+		minPVs int
+		want   []string
+	}{
+		{paras: map[string]string{VGNameParam: "data-a"}, minPVs: 1, want: []string{"data-a"}},
+		// vgpattern 只统计匹配的 vg, 不包括 system
+		{paras: map[string]string{VGPatternParam: "data-.*", VGPolicyParam: VGPolicyBinpack}, minPVs: 1, want: []string{"data-a", "data-b"}},
+		{paras: map[string]string{VGPatternParam: "data-.*"}, minPVs: 2, want: []string{"data-b"}},
+		{paras: map[string]string{}, minPVs: 1, want: []string{"data-a", "data-b", "system"}},
+	}
+	for _, c := range cases {
+		matched, err := filterVolumeGroups(vgInfos, c.paras, c.minPVs)
+		if err != nil {
+			t.Errorf("filter vgs with %v failed: %v", c.paras, err)
+			continue
+		}
+		var names []string
+		for _, vg := range matched {
+			names = append(names, vg.Name)
+		}
+		if !reflect.DeepEqual(names, c.want) {
+			t.Errorf("filter vgs with %v: expected %v, got %v", c.paras, c.want, names)
+		}
+	}
+
+	if _, err := filterVolumeGroups(vgInfos, map[string]string{VGPatternParam: "none"}, 1); !errors.Is(err, ErrVGNotFound) {
+		t.Errorf("expected ErrVGNotFound, got %v", err)
+	}
+	if _, err := filterVolumeGroups(vgInfos, map[string]string{VGPatternParam: "data-.*"}, 3); !errors.Is(err, ErrVGNotFound) {
+		t.Errorf("expected ErrVGNotFound without enough pvs, got %v", err)
+	}
+	if _, err := filterVolumeGroups(vgInfos, map[string]string{VGPatternParam: "data-.*", VGPolicyParam: "random"}, 1); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected ErrInvalidArgument, got %v", err)
+	}
+}
+
+func TestCalcLayoutCapacity(t *testing.T) {
+	vgInfos := []*VGInfo{
+		{Name: "data-a", Free: 400, PVCount: 2},
+		{Name: "data-b", Free: 1000, PVCount: 4},
+	}
+
+	// raid1 只能使用一半的空闲空间, 单个 volume 不能跨 vg
+	layout, err := ParseLayout(map[string]string{RaidTypeParam: RaidType1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capacity := calcLayoutCapacity(vgInfos, layout)
+	if capacity.Available != 700 || capacity.MaximumVolumeSize != 500 {
+		t.Errorf("unexpected raid1 capacity: %+v", capacity)
+	}
+
+	layout, err = ParseLayout(map[string]string{RaidTypeParam: RaidType5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capacity = calcLayoutCapacity(vgInfos, layout); capacity.MaximumVolumeSize != 666 {
+		t.Errorf("unexpected raid5 capacity: %+v", capacity)
+	}
+}
@@ -0,0 +1,338 @@
+package lvm
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+)
+
+/*
+根据数据源 (snapshot 或者 volume) 创建 lv
+1. 请求指定的 thin pool 与数据源所在的 pool 相同时, 直接对数据源再做一次 thin snapshot
+root@master:~# lvcreate -s -kn -n pvc-yyy lvmvg/snap-xxx
+2. 其他情况先按请求创建 lv, 再按块拷贝数据源的数据
+root@master:~# dd if=/dev/lvmvg/snap-xxx of=/dev/lvmvg/pvc-yyy bs=4M iflag=direct oflag=direct conv=fsync
+3. 数据源是正在使用的 volume 时, 先创建临时 snapshot, 从 snapshot 拷贝避免拷贝到不一致的数据
+lvcreate 创建 snapshot 时会 suspend origin 设备, 挂载在上面的文件系统会被 freeze 并刷盘
+root@master:~# lvcreate -s -n pvc-yyy-clonesrc -L 214748365b lvmvg/pvc-xxx
+4. 数据准备完成后打上 csi-source-ready tag, 重试时没有该 tag 的 lv 视为上次创建中断, 删除后重新创建
+root@master:~# lvchange --addtag csi-source-ready lvmvg/pvc-yyy
+*/
+
+const dd string = "dd"
+
+const (
+	// 拷贝时临时 snapshot 的名称为 <lv>-clonesrc
+	cloneSourceSuffix = "-clonesrc"
+	// 临时 COW snapshot 的大小占数据源大小的百分比, 拷贝期间数据源的写入超出该大小时 snapshot 失效, dd 读取失败后由 CO 重试
+	cloneSnapshotSizePercent = 20
+)
+
+// 数据拷贝完成后添加的 tag, 有 csi-source 但没有该 tag 的 lv 中的数据不完整
+const contentSourceReadyTag = "csi-source-ready"
+
+// 数据源的类型, 记录在 csi-source tag 中
+const (
+	contentSourceSnapshot = "snapshot"
+	contentSourceVolume   = "volume"
+)
+
+// ContentSourceTags 返回需要添加到 lv 上记录数据源的 tag, 没有数据源时不需要记录
+func ContentSourceTags(source *csi.VolumeContentSource) []string {
+	if value := contentSourceTagValue(source); len(value) > 0 {
+		return []string{contentSourceTagPrefix + value}
+	}
+	return nil
+}
+
+// 格式为 snapshot:<snapshot id> 或者 volume:<volume id>
+func contentSourceTagValue(source *csi.VolumeContentSource) string {
+	switch {
+	case source.GetSnapshot() != nil:
+		return contentSourceSnapshot + ":" + sanitizeTag(source.GetSnapshot().GetSnapshotId())
+	case source.GetVolume() != nil:
+		return contentSourceVolume + ":" + sanitizeTag(source.GetVolume().GetVolumeId())
+	}
+	return ""
+}
+
+// MatchContentSource 已存在的 lv 的数据源是否与请求中的一致, 都没有数据源时视为一致
+func MatchContentSource(source *csi.VolumeContentSource, lv *LVInfo) bool {
+	value, _ := lv.TagValue(contentSourceTagPrefix)
+	return contentSourceTagValue(source) == value
+}
+
+// IsIncompleteClone lv 是否为从数据源创建时中断遗留的 lv, 例如拷贝过程中 driver 重启
+func IsIncompleteClone(lv *LVInfo) bool {
+	_, ok := lv.TagValue(contentSourceTagPrefix)
+	return ok && !lv.HasTag(contentSourceReadyTag)
+}
+
+// RemoveIncompleteClone 同步清除并删除创建中断的 lv, 以及遗留的临时 snapshot
+func RemoveIncompleteClone(lv *LVInfo) error {
+	klog.Warningf("lv %s/%s is not completely created from its source, remove it", lv.VGName, lv.Name)
+	return removeLogicalVolume(&LogicalVolume{Path: lv.Path, Name: lv.Name, VGName: lv.VGName, Size: lv.Size}, true)
+}
+
+// 数据准备完成后标记 lv
+func markCloneReady(vgname, name string) error {
+	if _, err := runCommand(lvChange, "--addtag", contentSourceReadyTag, vgname+"/"+name); err != nil {
+		klog.Infof("mark lv ready failed, lvname: %s, vgname: %s\n", name, vgname)
+		return err
+	}
+	return nil
+}
+
+// SourceSize 返回数据源中数据的大小, snapshot 为 origin 的大小
+func SourceSize(source *LVInfo) int64 {
+	// COW snapshot 的 lv_size 是差异数据空间的大小
+	if len(source.Origin) > 0 && source.OriginSize > 0 {
+		return source.OriginSize
+	}
+	return source.Size
+}
+
+// CreateLogicalVolumeFromSource 以 source 的数据创建 lv
+func CreateLogicalVolumeFromSource(lv *LogicalVolume, source *LVInfo) error {
+	if source == nil {
+		return fmt.Errorf("%w: miss volume content source", ErrInvalidArgument)
+	}
+
+	if lv.Size < SourceSize(source) {
+		return fmt.Errorf("%w: requested size %d is smaller than the size %d of source %s/%s", ErrOutOfRange, lv.Size, SourceSize(source), source.VGName, source.Name)
+	}
+
+	if canThinClone(lv, source) {
+		return createThinClone(lv, source)
+	}
+	return createCopyClone(lv, source)
+}
+
+// 只有请求指定了数据源所在的 thin pool 时才能直接做 thin snapshot
+// 没有指定 thin pool 时需要创建普通 lv, 不能把 thin snapshot 交给只要求普通 lv 的 StorageClass
+func canThinClone(lv *LogicalVolume, source *LVInfo) bool {
+	return source.IsThin() && len(lv.ThinPool) > 0 && source.VGName == lv.VGName && lv.ThinPool == source.PoolLV
+}
+
+// lvcreate -s -kn -n pvc-yyy lvmvg/snap-xxx
+func createThinClone(lv *LogicalVolume, source *LVInfo) error {
+	var createLVArg []string
+
+	// 检查 lv 是否已经存在
+	exist, err := CheckVolumeExists(lv)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return fmt.Errorf("%w: %s/%s", ErrLVExists, lv.VGName, lv.Name)
+	}
+
+	// -kn 取消 thin snapshot 默认的 activation skip, 使新 lv 和普通 lv 一样可以直接使用
+	createLVArg = append(createLVArg, "-s", "-kn", "-n", lv.Name)
+	for _, tag := range lv.Tags {
+		createLVArg = append(createLVArg, "--addtag", tag)
+	}
+	createLVArg = append(createLVArg, source.VGName+"/"+source.Name)
+
+	create := func() error {
+		out, err := runCommand(lvCreate, createLVArg...)
+		if err != nil {
+			klog.Infof("create thin clone failed, lvname: %s, source: %s/%s\n", lv.Name, source.VGName, source.Name)
+			return err
+		}
+		klog.Info(string(out))
+
+		// 已经持有 thin pool 的锁, 并且按 lv.Size 预留了虚拟容量, 直接扩容
+		// 扩容失败时删除新建的 lv, 避免重试时一直返回大小不一致
+		if lv.Size > source.Size {
+			if err := extendThinClone(lv); err != nil {
+				if _, removeErr := runCommand(lvRemove, "-f", lv.VGName+"/"+lv.Name); removeErr != nil {
+					klog.Errorf("cleanup lv %s after extending failure failed: %v", lv.Name, removeErr)
+				}
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// 新 lv 的虚拟容量同样计入 thin pool 的超配比例
+	if err := withThinPool(lv, create); err != nil {
+		return err
+	}
+	return markCloneReady(lv.VGName, lv.Name)
+}
+
+func extendThinClone(lv *LogicalVolume) error {
+	clone, err := GetLogicalVolume(lv.VGName, lv.Name)
+	if err != nil {
+		return err
+	}
+	if clone == nil {
+		return fmt.Errorf("%w: %s/%s", ErrLVNotFound, lv.VGName, lv.Name)
+	}
+	return extendLogicalVolume(clone, lv.Size)
+}
+
+// 先创建普通 lv 再拷贝数据, 拷贝失败时删除新建的 lv
+func createCopyClone(lv *LogicalVolume, source *LVInfo) error {
+	if err := CreateLogicalVolume(lv); err != nil {
+		return err
+	}
+
+	// 拷贝了部分数据的 lv 需要同步清除后再删除, 避免重试时 lv 仍然存在
+	if err := copyFromSource(lv, source); err != nil {
+		if removeErr := removeLogicalVolume(lv, true); removeErr != nil {
+			klog.Errorf("cleanup lv %s after copy failure failed: %v", lv.Name, removeErr)
+		}
+		return err
+	}
+
+	return markCloneReady(lv.VGName, lv.Name)
+}
+
+// snapshot 的数据不会再变化, 直接拷贝; volume 可能正在被写入, 从临时 snapshot 拷贝
+func copyFromSource(lv *LogicalVolume, source *LVInfo) error {
+	if IsSnapshot(source) {
+		return copyData(lv, source)
+	}
+
+	snap, err := createCloneSnapshot(lv, source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := RemoveSnapshot(snap.VGName, snap.Name); err != nil {
+			klog.Errorf("remove temporary snapshot %s/%s failed: %v", snap.VGName, snap.Name, err)
+		}
+	}()
+
+	return copyData(lv, snap)
+}
+
+// 为数据源创建拷贝用的临时 snapshot, 上次拷贝中断时遗留的临时 snapshot 先删除
+func createCloneSnapshot(lv *LogicalVolume, source *LVInfo) (*LVInfo, error) {
+	if err := removeCloneSnapshot(lv.Name); err != nil {
+		return nil, err
+	}
+
+	return CreateSnapshot(newCloneSnapshot(lv, source))
+}
+
+// 删除拷贝中断时遗留的临时 snapshot, 临时 snapshot 在数据源所在的 vg 中, 需要在所有 vg 中查找
+func removeCloneSnapshot(name string) error {
+	snap, err := GetLogicalVolume("", name+cloneSourceSuffix)
+	if err != nil || snap == nil {
+		return err
+	}
+
+	klog.Infof("remove stale temporary snapshot %s/%s", snap.VGName, snap.Name)
+	return RemoveSnapshot(snap.VGName, snap.Name)
+}
+
+// thin 数据源创建 thin snapshot, 不需要指定大小
+func newCloneSnapshot(lv *LogicalVolume, source *LVInfo) *Snapshot {
+	snap := &Snapshot{
+		Name:   lv.Name + cloneSourceSuffix,
+		VGName: source.VGName,
+		Origin: source,
+	}
+	if !source.IsThin() {
+		// 向上取整, lvm 会再按 extent 对齐
+		snap.Size = (source.Size*cloneSnapshotSizePercent + 99) / 100
+	}
+	return snap
+}
+
+func copyData(lv *LogicalVolume, source *LVInfo) error {
+	// thin snapshot 默认不激活, 拷贝前需要先激活
+	if err := ActivateLogicalVolume(source.VGName, source.Name); err != nil {
+		return err
+	}
+
+	klog.Infof("copying data from %s to %s, size: %d", source.Path, lv.Path, SourceSize(source))
+
+	// 不使用 conv=sparse, 避免新 lv 上残留的旧数据被保留下来
+	out, err := runCommand(dd, "if="+source.Path, "of="+lv.Path, "bs=4M", "iflag=direct", "oflag=direct", "conv=fsync")
+	if err != nil {
+		klog.Infof("copy data failed, source: %s, target: %s\n", source.Path, lv.Path)
+		return err
+	}
+	klog.Info(string(out))
+
+	return nil
+}
+
+// ActivateLogicalVolume 激活 lv, -K 忽略 activation skip 标志
+// lvchange -ay -K lvmvg/snap-xxx
+func ActivateLogicalVolume(vgname, name string) error {
+	if _, err := runCommand(lvChange, "-ay", "-K", vgname+"/"+name); err != nil {
+		klog.Infof("activate lv failed, lvname: %s, vgname: %s\n", name, vgname)
+		return err
+	}
+
+	return nil
+}
+
+// ExtendLogicalVolume 将 lv 扩容到 size 大小, 挂了缓存的 lv 先拆下缓存, 扩容后重新挂上
+// thin lv 增加的虚拟容量同样计入 thin pool 的超配比例, 使用创建时记录的比例, 没有记录时使用 defaultRatio
+// lvextend -L 10737418240b lvmvg/pvc-xxx
+func ExtendLogicalVolume(vgname, name string, size int64, defaultRatio float64) error {
+	lvInfo, err := GetLogicalVolume(vgname, name)
+	if err != nil {
+		return err
+	}
+	if lvInfo == nil {
+		return fmt.Errorf("%w: %s/%s", ErrLVNotFound, vgname, name)
+	}
+
+	if !lvInfo.IsThin() {
+		return extendLogicalVolume(lvInfo, size)
+	}
+
+	ratio, err := lvInfo.OverprovisionRatio(defaultRatio)
+	if err != nil {
+		return err
+	}
+	// 只需要为增加的部分预留虚拟容量
+	lv := &LogicalVolume{
+		Name:               name,
+		VGName:             vgname,
+		Size:               size - lvInfo.Size,
+		ThinPool:           lvInfo.PoolLV,
+		OverprovisionRatio: ratio,
+	}
+	return withThinPool(lv, func() error {
+		return extendLogicalVolume(lvInfo, size)
+	})
+}
+
+func extendLogicalVolume(lvInfo *LVInfo, size int64) error {
+	vgname, name := lvInfo.VGName, lvInfo.Name
+
+	cache := lvInfo.Cache()
+	if cache != nil && lvInfo.IsCached() {
+		if err := detachCache(vgname, name, true); err != nil {
+			return err
+		}
+	}
+
+	out, err := runCommand(lvExtend, "-L", fmt.Sprintf("%db", size), vgname+"/"+name)
+	if err != nil {
+		klog.Infof("lvextend failed, lvname: %s, vgname: %s, size: %d\n", name, vgname, size)
+	} else {
+		klog.Info(string(out))
+	}
+
+	// 扩容失败时同样需要重新挂上缓存, 这里挂载失败时由重试的 ReattachCache 恢复
+	if cache != nil {
+		if attachErr := attachCache(vgname, name, size, cache); attachErr != nil {
+			klog.Errorf("reattach cache to lv %s/%s failed: %v", vgname, name, attachErr)
+			if err == nil {
+				err = attachErr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package lvm
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestCanThinClone(t *testing.T) {
+	thinSource := &LVInfo{Name: "pvc-1", VGName: "lvmvg", Attr: "Vwi-a-tz--", PoolLV: "pool"}
+	thickSource := &LVInfo{Name: "pvc-2", VGName: "lvmvg", Attr: "-wi-a-----"}
+
+	cases := []struct {
+		lv     *LogicalVolume
+		source *LVInfo
+		want   bool
+	}{
+		{lv: &LogicalVolume{Name: "pvc-3", VGName: "lvmvg", ThinPool: "pool"}, source: thinSource, want: true},
+		// 没有指定 thin pool 的 StorageClass 需要普通 lv
+		{lv: &LogicalVolume{Name: "pvc-3", VGName: "lvmvg"}, source: thinSource, want: false},
+		{lv: &LogicalVolume{Name: "pvc-3", VGName: "lvmvg", ThinPool: "other"}, source: thinSource, want: false},
+		{lv: &LogicalVolume{Name: "pvc-3", VGName: "othervg", ThinPool: "pool"}, source: thinSource, want: false},
+		{lv: &LogicalVolume{Name: "pvc-3", VGName: "lvmvg", ThinPool: "pool"}, source: thickSource, want: false},
+	}
+	for _, c := range cases {
+		if got := canThinClone(c.lv, c.source); got != c.want {
+			t.Errorf("canThinClone(%+v, %s) = %v, expected %v", c.lv, c.source.Name, got, c.want)
+		}
+	}
+}
+
+func TestNewCloneSnapshot(t *testing.T) {
+	lv := &LogicalVolume{Name: "pvc-3", VGName: "lvmvg"}
+
+	thick := &LVInfo{Name: "pvc-1", VGName: "lvmvg", Attr: "-wi-ao----", Size: 1000}
+	snap := newCloneSnapshot(lv, thick)
+	if snap.Name != "pvc-3"+cloneSourceSuffix || snap.VGName != "lvmvg" || snap.Origin != thick || snap.Size != 200 {
+		t.Errorf("unexpected snapshot of thick source: %+v", snap)
+	}
+
+	// thin snapshot 不需要指定大小
+	thin := &LVInfo{Name: "pvc-2", VGName: "lvmvg", Attr: "Vwi-aotz--", PoolLV: "pool", Size: 1000}
+	if snap = newCloneSnapshot(lv, thin); snap.Size != 0 {
+		t.Errorf("unexpected size %d of thin snapshot", snap.Size)
+	}
+}
+
+func TestMatchContentSource(t *testing.T) {
+	snapshot := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "lvmvg/pvc-1/snap-1"},
+		},
+	}
+	volume := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "lvmvg/pvc-1"},
+		},
+	}
+
+	lv := &LVInfo{Name: "pvc-2", VGName: "lvmvg", Tags: ContentSourceTags(snapshot)}
+	if !MatchContentSource(snapshot, lv) {
+		t.Errorf("expected snapshot source to match tags %v", lv.Tags)
+	}
+	if MatchContentSource(volume, lv) || MatchContentSource(nil, lv) {
+		t.Errorf("expected other sources not to match tags %v", lv.Tags)
+	}
+
+	// 没有数据源的 lv 只匹配没有数据源的请求
+	lv = &LVInfo{Name: "pvc-3", VGName: "lvmvg", Tags: ContentSourceTags(nil)}
+	if len(lv.Tags) != 0 || !MatchContentSource(nil, lv) || MatchContentSource(volume, lv) {
+		t.Errorf("unexpected match for lv without source, tags %v", lv.Tags)
+	}
+}
+
+func TestIsIncompleteClone(t *testing.T) {
+	source := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "lvmvg/pvc-1"},
+		},
+	}
+
+	// 拷贝完成前 driver 重启, lv 上只有 csi-source 没有 csi-source-ready
+	lv := &LVInfo{Name: "pvc-2", VGName: "lvmvg", Tags: ContentSourceTags(source)}
+	if !IsIncompleteClone(lv) {
+		t.Errorf("lv %s without ready tag should be incomplete", lv.Name)
+	}
+
+	lv.Tags = append(lv.Tags, contentSourceReadyTag)
+	if IsIncompleteClone(lv) {
+		t.Errorf("lv %s with ready tag should be complete", lv.Name)
+	}
+	if lv = (&LVInfo{Name: "pvc-3", VGName: "lvmvg"}); IsIncompleteClone(lv) {
+		t.Errorf("lv %s without source should not be a clone", lv.Name)
+	}
+}
@@ -0,0 +1,105 @@
+package lvm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+)
+
+// lvm 模块的错误类型, driver 根据错误类型返回对应的 gRPC status code
+var (
+	ErrInvalidArgument   = errors.New("invalid argument")
+	ErrOutOfRange        = errors.New("out of range")
+	ErrVGNotFound        = errors.New("volume group not found")
+	ErrLVNotFound        = errors.New("logical volume not found")
+	ErrLVExists          = errors.New("logical volume already exists")
+	ErrInsufficientSpace = errors.New("insufficient free space")
+	ErrBusy              = errors.New("operation in progress")
+	ErrInUse             = errors.New("logical volume in use")
+	ErrHasSnapshots      = errors.New("logical volume has snapshots")
+)
+
+// 根据 lvm 命令的输出判断错误类型, 匹配时忽略大小写
+var commandErrorPatterns = []struct {
+	pattern string
+	kind    error
+}{
+	{"insufficient free space", ErrInsufficientSpace},
+	{"insufficient suitable allocatable extents", ErrInsufficientSpace},
+	{"failed to find logical volume", ErrLVNotFound},
+	{"already exists in volume group", ErrLVExists},
+	{"can't get lock", ErrBusy},
+	{"failed to lock", ErrBusy},
+	{"can't remove open logical volume", ErrInUse},
+	{"contains a filesystem in use", ErrInUse},
+	{"volume group \"", ErrVGNotFound},
+}
+
+// CommandError 保留命令失败时的输出, 方便排查问题
+type CommandError struct {
+	Cmd    string
+	Args   []string
+	Output string
+	Err    error
+	// 根据输出判断出的错误类型, 无法判断时为 nil
+	Kind error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s %s failed: %v, output: %s", e.Cmd, strings.Join(e.Args, " "), e.Err, e.Output)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Kind
+}
+
+func newCommandError(cmd string, args []string, output []byte, err error) *CommandError {
+	cmdErr := &CommandError{
+		Cmd:    cmd,
+		Args:   args,
+		Output: strings.TrimSpace(string(output)),
+		Err:    err,
+	}
+
+	lowerOutput := strings.ToLower(cmdErr.Output)
+	for _, p := range commandErrorPatterns {
+		if !strings.Contains(lowerOutput, p.pattern) {
+			continue
+		}
+		// "Volume group "xxx" not found" 需要同时包含 not found
+		if p.kind == ErrVGNotFound && !strings.Contains(lowerOutput, "not found") {
+			continue
+		}
+		cmdErr.Kind = p.kind
+		break
+	}
+
+	return cmdErr
+}
+
+// 执行命令, 失败时返回带有命令输出的 CommandError
+func runCommand(cmd string, args ...string) ([]byte, error) {
+	exec := exec.New()
+	out, err := exec.Command(cmd, args...).CombinedOutput()
+	if err != nil {
+		return out, newCommandError(cmd, args, out, err)
+	}
+	return out, nil
+}
+
+// 执行 lvs/vgs 等报告类命令, stdout 为 json 格式的报告, 失败时使用 stderr 生成 CommandError
+func runReportCommand(cmd string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	c := exec.New().Command(cmd, args...)
+	c.SetStdout(&stdout)
+	c.SetStderr(&stderr)
+	if err := c.Run(); err != nil {
+		return nil, newCommandError(cmd, args, stderr.Bytes(), err)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package lvm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewCommandError(t *testing.T) {
+	cases := []struct {
+		output string
+		kind   error
+	}{
+		{`  Volume group "lvmvg" not found`, ErrVGNotFound},
+		{`  Volume group "lvmvg" has insufficient free space (10 extents): 256 required.`, ErrInsufficientSpace},
+		{`  Failed to find logical volume "lvmvg/pvc-1"`, ErrLVNotFound},
+		{`  Logical Volume "pvc-1" already exists in volume group "lvmvg"`, ErrLVExists},
+		{`  Insufficient suitable allocatable extents for logical volume pvc-1: 100 more required`, ErrInsufficientSpace},
+		{`  Logical volume lvmvg/pvc-1 contains a filesystem in use.`, ErrInUse},
+		{`  Can't remove open logical volume "pvc-1".`, ErrInUse},
+		{`  unexpected failure`, nil},
+	}
+
+	for _, c := range cases {
+		err := newCommandError(lvCreate, []string{"-n", "pvc-1"}, []byte(c.output), errors.New("exit status 5"))
+		if c.kind == nil {
+			if err.Kind != nil {
+				t.Errorf("output %q: expected no kind, got %v", c.output, err.Kind)
+			}
+		} else if !errors.Is(err, c.kind) {
+			t.Errorf("output %q: expected %v, got %v", c.output, c.kind, err.Kind)
+		}
+
+		// 错误信息中需要保留命令的输出
+		if !strings.Contains(err.Error(), strings.TrimSpace(c.output)) {
+			t.Errorf("error message %q doesn't contain output", err.Error())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package lvm
+
+import (
+	"fmt"
+)
+
+/*
+通过 lv_attr 和 data_percent 判断 lv 的健康状态
+root@master:~# lvs -o lv_name,lv_attr,origin,data_percent lvmvg
+  LV      Attr       Origin  Data%
+  pool    twi-aotz--         95.12
+  pvc-1   -wi-ao----
+  pvc-2   Vwi-a-tz--         10.00
+  snap-1  swi-I-s--- pvc-1   100.00
+*/
+
+// CheckVolumeHealth 检查 lv 自身的状态, 返回发现的问题, 正常时返回空
+func CheckVolumeHealth(lv *LVInfo) []string {
+	var problems []string
+
+	switch state := lv.attrAt(lvAttrStateIndex); {
+	case state == 'I':
+		problems = append(problems, "snapshot is invalid")
+	case !lv.IsActive():
+		problems = append(problems, "lv is not active")
+	}
+
+	// COW snapshot 的空间用满后会失效
+	if len(lv.Origin) > 0 && !lv.IsThin() && lv.DataPercent >= 100 {
+		problems = append(problems, "snapshot space is full")
+	}
+
+	// 扩容中断等情况下缓存可能没有重新挂上
+	if lv.Cache() != nil && !lv.IsCached() {
+		problems = append(problems, "cache is not attached")
+	}
+
+	switch lv.attrAt(lvAttrHealthIndex) {
+	case 'p':
+		problems = append(problems, "lv is partial, one or more pvs are missing")
+	case 'r':
+		problems = append(problems, "lv needs refresh")
+	case 'm':
+		problems = append(problems, "lv has mismatches")
+	case 'X':
+		problems = append(problems, "lv health is unknown")
+	}
+
+	return problems
+}
+
+// CheckThinPoolHealth 检查 thin pool 的状态, threshold 为数据空间使用率的告警百分比, 小于等于 0 时不检查使用率
+func CheckThinPoolHealth(pool *LVInfo, threshold int) []string {
+	var problems []string
+
+	switch pool.attrAt(lvAttrHealthIndex) {
+	case 'F':
+		problems = append(problems, fmt.Sprintf("thin pool %s has failed", pool.Name))
+	case 'D':
+		problems = append(problems, fmt.Sprintf("thin pool %s is out of data space", pool.Name))
+	case 'M':
+		problems = append(problems, fmt.Sprintf("metadata of thin pool %s is read only", pool.Name))
+	}
+
+	if threshold > 0 && pool.DataPercent >= float64(threshold) {
+		problems = append(problems, fmt.Sprintf("thin pool %s data usage %.2f%% exceeds threshold %d%%", pool.Name, pool.DataPercent, threshold))
+	}
+
+	return problems
+}
+
+// ThinPoolKey 生成 ListThinPools 返回结果中 thin pool 的 key
+func ThinPoolKey(vgname, pool string) string {
+	return vgname + "/" + pool
+}
+
+// ListThinPools 查询所有 vg 中的 thin pool, key 为 vgname/poolname
+func ListThinPools() (map[string]*LVInfo, error) {
+	lvInfos, err := ListLogicalVolumes("")
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make(map[string]*LVInfo)
+	for _, lv := range lvInfos {
+		if lv.IsThinPool() {
+			pools[ThinPoolKey(lv.VGName, lv.Name)] = lv
+		}
+	}
+
+	return pools, nil
+}
@@ -0,0 +1,40 @@
+package lvm
+
+import (
+	"testing"
+)
+
+func TestCheckVolumeHealth(t *testing.T) {
+	cases := []struct {
+		lv       *LVInfo
+		problems int
+	}{
+		{lv: &LVInfo{Name: "pvc-1", Attr: "-wi-ao----"}, problems: 0},
+		{lv: &LVInfo{Name: "pvc-2", Attr: "-wi-------"}, problems: 1},
+		{lv: &LVInfo{Name: "pvc-3", Attr: "-wi-a---p-"}, problems: 1},
+		{lv: &LVInfo{Name: "snap-1", Attr: "swi-I-s---", Origin: "pvc-1", DataPercent: 100}, problems: 2},
+		{lv: &LVInfo{Name: "pvc-4", Attr: "Vwi-a-tz--", Origin: "pvc-1", PoolLV: "pool", DataPercent: 100}, problems: 0},
+	}
+
+	for _, c := range cases {
+		if problems := CheckVolumeHealth(c.lv); len(problems) != c.problems {
+			t.Errorf("expected %d problems for lv %s, got %v", c.problems, c.lv.Name, problems)
+		}
+	}
+}
+
+func TestCheckThinPoolHealth(t *testing.T) {
+	pool := &LVInfo{Name: "pool", Attr: "twi-aotz--", DataPercent: 95}
+
+	if problems := CheckThinPoolHealth(pool, 90); len(problems) != 1 {
+		t.Errorf("expected usage problem, got %v", problems)
+	}
+	if problems := CheckThinPoolHealth(pool, 0); len(problems) != 0 {
+		t.Errorf("expected no problem when threshold is disabled, got %v", problems)
+	}
+
+	pool.Attr = "twi-aotzD-"
+	if problems := CheckThinPoolHealth(pool, 0); len(problems) != 1 {
+		t.Errorf("expected out of data space problem, got %v", problems)
+	}
+}
@@ -0,0 +1,98 @@
+package lvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/houwenchen/kubernetes-csi/pkg/mount"
+	"k8s.io/klog/v2"
+)
+
+/*
+删除 lv 前检查 lv 是否仍在使用, 避免误删除还在被 pod 使用的 volume
+1. lv_attr 的第六位为 o 时设备被打开
+root@master:~# lvs -o lv_name,lv_attr lvmvg
+  pvc-xxx -wi-ao----
+2. /proc/self/mountinfo 中有 lv 的设备号或者设备文件的 bind mount 时 lv 仍被挂载
+强制删除: StorageClass 中指定 forceDelete=true, 或者由运维人员手动给卡住的 lv 打上 tag
+root@master:~# lvchange --addtag csi-forceDelete=true lvmvg/pvc-xxx
+强制删除时先卸载 lv 的所有挂载点, 设备仍被其他进程打开时 lvremove 依然会失败
+*/
+
+// StorageClass 中允许强制删除仍在使用的 lv 的参数
+const ForceDeleteParam = "forceDelete"
+
+// ParseForceDelete 解析参数中的 forceDelete, 没有指定时为 false
+func ParseForceDelete(paras map[string]string) (bool, error) {
+	v, ok := paras[ForceDeleteParam]
+	if !ok {
+		return false, nil
+	}
+
+	force, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid %s %q", ErrInvalidArgument, ForceDeleteParam, v)
+	}
+	return force, nil
+}
+
+// ForceDeleteTags 返回需要添加到 lv 上记录 forceDelete 的 tag, false 时不需要记录
+func ForceDeleteTags(force bool) []string {
+	if !force {
+		return nil
+	}
+	return []string{paramTag(ForceDeleteParam, strconv.FormatBool(force))}
+}
+
+// ForceDelete 是否允许强制删除仍在使用的 lv
+func (lv *LVInfo) ForceDelete() bool {
+	v, ok := lv.paramTagValue(ForceDeleteParam)
+	if !ok {
+		return false
+	}
+	force, _ := strconv.ParseBool(v)
+	return force
+}
+
+// 检查 lv 是否仍在使用, 使用中返回 ErrInUse, 允许强制删除时卸载 lv 的所有挂载点
+func checkNotInUse(lv *LVInfo) error {
+	mounts, err := mount.GetMountsByDevice(lv.Path)
+	if err != nil {
+		return err
+	}
+
+	reason := inUseReason(lv, mounts)
+	if len(reason) == 0 {
+		return nil
+	}
+
+	id := VolumeID(lv.VGName, lv.Name)
+	if !lv.ForceDelete() {
+		return fmt.Errorf("%w: lv %s %s", ErrInUse, id, reason)
+	}
+
+	klog.Warningf("lv %s %s, force deleting it", id, reason)
+	// 嵌套的挂载点需要先卸载
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if err := mount.Unmount(mounts[i].MountPoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 返回 lv 正在被使用的原因, 没有被使用时返回空
+func inUseReason(lv *LVInfo, mounts []mount.MountInfo) string {
+	if len(mounts) > 0 {
+		var mountPoints []string
+		for _, m := range mounts {
+			mountPoints = append(mountPoints, m.MountPoint)
+		}
+		return "is mounted at " + strings.Join(mountPoints, ", ")
+	}
+	if lv.IsOpen() {
+		return fmt.Sprintf("is open (attr %s)", lv.Attr)
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package lvm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/houwenchen/kubernetes-csi/pkg/mount"
+)
+
+func TestParseForceDelete(t *testing.T) {
+	if force, err := ParseForceDelete(map[string]string{}); err != nil || force {
+		t.Errorf("expected force delete disabled by default, got %v, %v", force, err)
+	}
+	if _, err := ParseForceDelete(map[string]string{ForceDeleteParam: "yes please"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected ErrInvalidArgument, got %v", err)
+	}
+
+	force, err := ParseForceDelete(map[string]string{ForceDeleteParam: "true"})
+	if err != nil || !force {
+		t.Fatalf("expected force delete, got %v, %v", force, err)
+	}
+	if lv := (&LVInfo{Tags: ForceDeleteTags(force)}); !lv.ForceDelete() {
+		t.Errorf("expected force delete from tags %v", lv.Tags)
+	}
+	if lv := (&LVInfo{Tags: ForceDeleteTags(false)}); lv.ForceDelete() {
+		t.Errorf("expected no force delete from tags %v", lv.Tags)
+	}
+}
+
+func TestInUseReason(t *testing.T) {
+	idle := &LVInfo{Name: "pvc-1", Attr: "-wi-a-----"}
+	if reason := inUseReason(idle, nil); len(reason) > 0 {
+		t.Errorf("expected idle lv not in use, got %q", reason)
+	}
+
+	open := &LVInfo{Name: "pvc-1", Attr: "-wi-ao----"}
+	if reason := inUseReason(open, nil); reason != "is open (attr -wi-ao----)" {
+		t.Errorf("unexpected reason for open lv: %q", reason)
+	}
+
+	mounts := []mount.MountInfo{{MountPoint: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-1/mount"}}
+	if reason := inUseReason(open, mounts); reason != "is mounted at "+mounts[0].MountPoint {
+		t.Errorf("unexpected reason for mounted lv: %q", reason)
+	}
+}
@@ -0,0 +1,269 @@
+package lvm
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+/*
+通过 StorageClass 参数指定 lv 的布局
+1. 条带: lvcreate -n pvc-xxx -L 10737418240b -i 2 -I 64k lvmvg
+2. 镜像: lvcreate -n pvc-xxx -L 10737418240b --type raid1 -m 1 lvmvg
+3. raid: lvcreate -n pvc-xxx -L 10737418240b --type raid10 -i 2 -m 1 lvmvg
+*/
+
+// StorageClass 中 lv 布局相关的参数, 同时作为 VolumeContext 的 key
+const (
+	StripesParam    = "stripes"
+	StripeSizeParam = "stripesize"
+	MirrorsParam    = "mirrors"
+	RaidTypeParam   = "raidtype"
+)
+
+// 支持的 raid 类型
+const (
+	RaidType0  = "raid0"
+	RaidType1  = "raid1"
+	RaidType5  = "raid5"
+	RaidType6  = "raid6"
+	RaidType10 = "raid10"
+)
+
+// 创建时记录到 lv 的 tag 中的布局参数
+var layoutKeys = []string{RaidTypeParam, StripesParam, StripeSizeParam, MirrorsParam}
+
+// stripesize 为 2 的幂, 可以带 k/m 单位, 不带单位时 lvm 按 KiB 处理
+var stripeSizePattern = regexp.MustCompile(`^[0-9]+[kKmM]?$`)
+
+// Layout 为 lv 的布局, 零值表示普通的线性 lv
+type Layout struct {
+	RaidType   string
+	Stripes    int
+	StripeSize string
+	Mirrors    int
+}
+
+// ParseLayout 解析并校验参数中的 lv 布局
+func ParseLayout(paras map[string]string) (*Layout, error) {
+	layout := &Layout{
+		RaidType:   paras[RaidTypeParam],
+		StripeSize: paras[StripeSizeParam],
+	}
+
+	var err error
+	if layout.Stripes, err = parseLayoutInt(paras, StripesParam, 1); err != nil {
+		return nil, err
+	}
+	if layout.Mirrors, err = parseLayoutInt(paras, MirrorsParam, 0); err != nil {
+		return nil, err
+	}
+	if len(layout.StripeSize) > 0 && !stripeSizePattern.MatchString(layout.StripeSize) {
+		return nil, fmt.Errorf("%w: invalid %s %q", ErrInvalidArgument, StripeSizeParam, layout.StripeSize)
+	}
+
+	_, hasStripes := paras[StripesParam]
+	_, hasMirrors := paras[MirrorsParam]
+
+	switch layout.RaidType {
+	case "":
+		// 同时指定条带和镜像时需要使用 raid10
+		if layout.Stripes > 1 && layout.Mirrors > 0 {
+			return nil, fmt.Errorf("%w: %s and %s can't be used together without %s %s", ErrInvalidArgument, StripesParam, MirrorsParam, RaidTypeParam, RaidType10)
+		}
+	case RaidType0:
+		if !hasStripes {
+			layout.Stripes = 2
+		}
+		if layout.Stripes < 2 || layout.Mirrors > 0 {
+			return nil, fmt.Errorf("%w: %s requires at least 2 %s and no %s", ErrInvalidArgument, RaidType0, StripesParam, MirrorsParam)
+		}
+	case RaidType1:
+		if !hasMirrors {
+			layout.Mirrors = 1
+		}
+		if layout.Mirrors < 1 || layout.Stripes > 1 {
+			return nil, fmt.Errorf("%w: %s requires at least 1 %s and no %s", ErrInvalidArgument, RaidType1, MirrorsParam, StripesParam)
+		}
+	case RaidType5, RaidType6:
+		// stripes 为数据盘的数量, 不包括校验盘
+		minStripes := 2
+		if layout.RaidType == RaidType6 {
+			minStripes = 3
+		}
+		if !hasStripes {
+			layout.Stripes = minStripes
+		}
+		if layout.Stripes < minStripes || layout.Mirrors > 0 {
+			return nil, fmt.Errorf("%w: %s requires at least %d %s and no %s", ErrInvalidArgument, layout.RaidType, minStripes, StripesParam, MirrorsParam)
+		}
+	case RaidType10:
+		if !hasStripes {
+			layout.Stripes = 2
+		}
+		if !hasMirrors {
+			layout.Mirrors = 1
+		}
+		if layout.Stripes < 2 || layout.Mirrors < 1 {
+			return nil, fmt.Errorf("%w: %s requires at least 2 %s and 1 %s", ErrInvalidArgument, RaidType10, StripesParam, MirrorsParam)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported %s %q", ErrInvalidArgument, RaidTypeParam, layout.RaidType)
+	}
+
+	if len(layout.StripeSize) > 0 && layout.Stripes < 2 {
+		return nil, fmt.Errorf("%w: %s requires at least 2 %s", ErrInvalidArgument, StripeSizeParam, StripesParam)
+	}
+
+	return layout, nil
+}
+
+func parseLayoutInt(paras map[string]string, key string, min int) (int, error) {
+	v, ok := paras[key]
+	if !ok {
+		return min, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < min {
+		return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidArgument, key, v)
+	}
+	return i, nil
+}
+
+// IsLinear 是否为普通的线性 lv, nil 视为线性
+func (l *Layout) IsLinear() bool {
+	return l == nil || len(l.RaidType) == 0 && l.Stripes <= 1 && l.Mirrors == 0
+}
+
+// RequiredPVs 布局需要的最少 pv 数量, 每个条带和镜像需要位于不同的 pv 上
+func (l *Layout) RequiredPVs() int {
+	switch l.RaidType {
+	case RaidType5:
+		return l.Stripes + 1
+	case RaidType6:
+		return l.Stripes + 2
+	case RaidType10:
+		return l.Stripes * (l.Mirrors + 1)
+	}
+
+	if l.Mirrors > 0 {
+		return l.Mirrors + 1
+	}
+	if l.Stripes > 1 {
+		return l.Stripes
+	}
+	return 1
+}
+
+// 保存数据的 pv 数量, 其余 pv 保存镜像或者校验数据
+func (l *Layout) dataPVs() int64 {
+	if l.Stripes < 1 {
+		return 1
+	}
+	return int64(l.Stripes)
+}
+
+// UsableSpace 返回 free 大小的空闲空间按布局能够创建的 lv 大小, 例如 raid1 为空闲空间的一半
+func (l *Layout) UsableSpace(free int64) int64 {
+	return free * l.dataPVs() / int64(l.RequiredPVs())
+}
+
+// RequiredSpace 返回按布局创建 size 大小的 lv 需要的空闲空间, 与 UsableSpace 相反
+func (l *Layout) RequiredSpace(size int64) int64 {
+	dataPVs := l.dataPVs()
+	return (size*int64(l.RequiredPVs()) + dataPVs - 1) / dataPVs
+}
+
+// Args 返回 lvcreate 中布局相关的参数
+func (l *Layout) Args() []string {
+	var args []string
+
+	raidType := l.RaidType
+	// 只指定 mirrors 时使用 raid1
+	if len(raidType) == 0 && l.Mirrors > 0 {
+		raidType = RaidType1
+	}
+	if len(raidType) > 0 {
+		args = append(args, "--type", raidType)
+	}
+
+	if l.Stripes > 1 {
+		args = append(args, "-i", strconv.Itoa(l.Stripes))
+	}
+	if len(l.StripeSize) > 0 {
+		args = append(args, "-I", l.StripeSize)
+	}
+	if l.Mirrors > 0 {
+		args = append(args, "-m", strconv.Itoa(l.Mirrors))
+	}
+
+	return args
+}
+
+// VolumeContext 返回需要记录到 VolumeContext 中的布局信息, 线性 lv 返回空
+func (l *Layout) VolumeContext() map[string]string {
+	ctx := make(map[string]string)
+	if l.IsLinear() {
+		return ctx
+	}
+
+	if len(l.RaidType) > 0 {
+		ctx[RaidTypeParam] = l.RaidType
+	}
+	if l.Stripes > 1 {
+		ctx[StripesParam] = strconv.Itoa(l.Stripes)
+	}
+	if len(l.StripeSize) > 0 {
+		ctx[StripeSizeParam] = l.StripeSize
+	}
+	if l.Mirrors > 0 {
+		ctx[MirrorsParam] = strconv.Itoa(l.Mirrors)
+	}
+	return ctx
+}
+
+// Tags 返回需要添加到 lv 上记录布局的 tag
+func (l *Layout) Tags() []string {
+	var tags []string
+	ctx := l.VolumeContext()
+	for _, key := range layoutKeys {
+		if v, ok := ctx[key]; ok {
+			tags = append(tags, paramTag(key, v))
+		}
+	}
+	return tags
+}
+
+// LayoutContext 从 lv 的 tag 中读取创建时记录的布局, 线性 lv 返回空
+func (lv *LVInfo) LayoutContext() map[string]string {
+	ctx := make(map[string]string)
+	for _, key := range layoutKeys {
+		if v, ok := lv.paramTagValue(key); ok {
+			ctx[key] = v
+		}
+	}
+	return ctx
+}
+
+// MatchLayout 已存在的 lv 的布局是否与参数中的一致
+func MatchLayout(paras map[string]string, lv *LVInfo) (bool, error) {
+	layout, err := ParseLayout(paras)
+	if err != nil {
+		return false, err
+	}
+
+	return equalContext(layout.VolumeContext(), lv.LayoutContext()), nil
+}
+
+func equalContext(want, got map[string]string) bool {
+	if len(want) != len(got) {
+		return false
+	}
+	for k, v := range want {
+		if gv, ok := got[k]; !ok || gv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package lvm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseLayout(t *testing.T) {
+	cases := []struct {
+		paras       map[string]string
+		args        []string
+		requiredPVs int
+	}{
+		{paras: map[string]string{}, args: nil, requiredPVs: 1},
+		{paras: map[string]string{StripesParam: "3", StripeSizeParam: "64k"}, args: []string{"-i", "3", "-I", "64k"}, requiredPVs: 3},
+		{paras: map[string]string{MirrorsParam: "2"}, args: []string{"--type", "raid1", "-m", "2"}, requiredPVs: 3},
+		{paras: map[string]string{RaidTypeParam: RaidType0}, args: []string{"--type", "raid0", "-i", "2"}, requiredPVs: 2},
+		{paras: map[string]string{RaidTypeParam: RaidType1}, args: []string{"--type", "raid1", "-m", "1"}, requiredPVs: 2},
+		{paras: map[string]string{RaidTypeParam: RaidType5}, args: []string{"--type", "raid5", "-i", "2"}, requiredPVs: 3},
+		{paras: map[string]string{RaidTypeParam: RaidType6, StripesParam: "4"}, args: []string{"--type", "raid6", "-i", "4"}, requiredPVs: 6},
+		{paras: map[string]string{RaidTypeParam: RaidType10, StripeSizeParam: "128k"}, args: []string{"--type", "raid10", "-i", "2", "-I", "128k", "-m", "1"}, requiredPVs: 4},
+	}
+	for _, c := range cases {
+		layout, err := ParseLayout(c.paras)
+		if err != nil {
+			t.Errorf("parse layout %v failed: %v", c.paras, err)
+			continue
+		}
+		if args := layout.Args(); !reflect.DeepEqual(args, c.args) {
+			t.Errorf("layout %v: expected args %v, got %v", c.paras, c.args, args)
+		}
+		if n := layout.RequiredPVs(); n != c.requiredPVs {
+			t.Errorf("layout %v: expected %d pvs, got %d", c.paras, c.requiredPVs, n)
+		}
+	}
+}
+
+func TestParseLayoutInvalid(t *testing.T) {
+	for _, paras := range []map[string]string{
+		{StripesParam: "0"},
+		{StripesParam: "abc"},
+		{MirrorsParam: "-1"},
+		{StripeSizeParam: "64x", StripesParam: "2"},
+		// stripesize 只对条带有效
+		{StripeSizeParam: "64k"},
+		{StripesParam: "2", MirrorsParam: "1"},
+		{RaidTypeParam: "raid4"},
+		{RaidTypeParam: RaidType0, StripesParam: "1"},
+		{RaidTypeParam: RaidType1, StripesParam: "2"},
+		{RaidTypeParam: RaidType5, MirrorsParam: "1"},
+		{RaidTypeParam: RaidType6, StripesParam: "2"},
+		{RaidTypeParam: RaidType10, MirrorsParam: "0"},
+	} {
+		if _, err := ParseLayout(paras); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("expected ErrInvalidArgument for %v, got %v", paras, err)
+		}
+	}
+}
+
+func TestLayoutTags(t *testing.T) {
+	paras := map[string]string{RaidTypeParam: RaidType10, StripesParam: "3", StripeSizeParam: "64k"}
+	layout, err := ParseLayout(paras)
+	if err != nil {
+		t.Fatalf("parse layout failed: %v", err)
+	}
+
+	lv := &LVInfo{Name: "pvc-1", VGName: "lvmvg", Tags: layout.Tags()}
+	want := map[string]string{RaidTypeParam: RaidType10, StripesParam: "3", StripeSizeParam: "64k", MirrorsParam: "1"}
+	if ctx := lv.LayoutContext(); !reflect.DeepEqual(ctx, want) {
+		t.Errorf("expected layout context %v, got %v", want, ctx)
+	}
+
+	if matched, err := MatchLayout(paras, lv); err != nil || !matched {
+		t.Errorf("expected layout to match, got %v, %v", matched, err)
+	}
+	if matched, err := MatchLayout(map[string]string{}, lv); err != nil || matched {
+		t.Errorf("expected linear layout not to match, got %v, %v", matched, err)
+	}
+
+	// 线性 lv 没有布局相关的 tag
+	linear, _ := ParseLayout(map[string]string{})
+	if tags := linear.Tags(); len(tags) != 0 {
+		t.Errorf("expected no tags for linear layout, got %v", tags)
+	}
+}
+
+func TestLayoutSpace(t *testing.T) {
+	cases := []struct {
+		layout   *Layout
+		size     int64
+		required int64
+	}{
+		{layout: &Layout{Stripes: 1}, size: 100, required: 100},
+		{layout: &Layout{Stripes: 2}, size: 100, required: 100},
+		{layout: &Layout{RaidType: RaidType1, Stripes: 1, Mirrors: 1}, size: 100, required: 200},
+		{layout: &Layout{RaidType: RaidType5, Stripes: 2}, size: 100, required: 150},
+		{layout: &Layout{RaidType: RaidType10, Stripes: 2, Mirrors: 1}, size: 100, required: 200},
+	}
+	for _, c := range cases {
+		if got := c.layout.RequiredSpace(c.size); got != c.required {
+			t.Errorf("layout %+v: expected required space %d, got %d", c.layout, c.required, got)
+		}
+		// 按需要的空间折算回来的可用大小不能小于请求的大小
+		if got := c.layout.UsableSpace(c.layout.RequiredSpace(c.size)); got < c.size {
+			t.Errorf("layout %+v: usable space %d is smaller than size %d", c.layout, got, c.size)
+		}
+	}
+}
@@ -1,12 +1,9 @@
 package lvm
 
 import (
-	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 
-	"github.com/caoyingjunz/pixiulib/exec"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/houwenchen/kubernetes-csi/pkg/config"
 	"k8s.io/klog/v2"
@@ -56,14 +53,26 @@ root@master:~/tmp/openebs# vgcreate lvmvg /dev/loop10
 root@master:~/tmp/openebs# vgs
   VG    #PV #LV #SN Attr   VSize   VFree
   lvmvg   1   0   0 wz--n- <10.00g <10.00g
+
+步骤 2 和 3 也可以通过 --provision-vg 在 node 启动时自动完成, 详见 provision.go
 */
 
 // lvm 模块所需命令
 const (
-	lvCreate string = "lvcreate"
-	lvRemove string = "lvremove"
+	lvCreate  string = "lvcreate"
+	lvRemove  string = "lvremove"
+	lvs       string = "lvs"
+	vgs       string = "vgs"
+	pvs       string = "pvs"
+	dmsetup   string = "dmsetup"
+	lvChange  string = "lvchange"
+	lvExtend  string = "lvextend"
+	lvConvert string = "lvconvert"
 )
 
+// 没有指定容量时创建的 lv 大小
+const defaultVolumeSize int64 = 1024 * 1024 * 1024
+
 type LogicalVolume struct {
 	Path     string
 	Name     string
@@ -71,56 +80,180 @@ type LogicalVolume struct {
 	UUID     string
 	LVAccess []string
 	LVStatus string
-	Size     string
+	// 单位为 byte
+	Size int64
+	// 创建时添加到 lv 上的 tag
+	Tags []string
+
+	// 不为空时在该 thin pool 中创建 thin lv
+	ThinPool            string
+	ThinPoolAutoCreate  bool
+	ThinPoolSizePercent int
+	// thin pool 的超配比例, 小于等于 0 时不限制
+	OverprovisionRatio float64
+
+	// 条带、镜像或 raid 布局, 为空时创建线性 lv
+	Layout *Layout
+	// 不为空时在快速设备上为 lv 添加缓存
+	Cache *Cache
 }
 
 // 根据 CreateVolumeRequest 生成 LV
 func NewLogicalVolumeForCreate(config *config.Config, req *csi.CreateVolumeRequest) (*LogicalVolume, error) {
 	name := req.GetName()
 	paras := req.GetParameters()
-	vgname, ok := paras["vgname"]
-	if !ok {
-		klog.Info("create volume request sholud contain para of vgname")
-		return nil, errors.New("miss vgname")
+
+	// 优先使用 RequiredBytes, 没有指定时使用 LimitBytes, 都没有指定时使用默认大小
+	size := req.GetCapacityRange().GetRequiredBytes()
+	if size == 0 {
+		size = req.GetCapacityRange().GetLimitBytes()
+	}
+	if size == 0 {
+		size = defaultVolumeSize
+	}
+
+	layout, err := ParseLayout(paras)
+	if err != nil {
+		return nil, err
+	}
+	// thin lv 的布局由 thin pool 决定
+	if len(paras[ThinPoolParam]) > 0 && !layout.IsLinear() {
+		return nil, fmt.Errorf("%w: %s can't be used with stripes, mirrors or raidtype", ErrInvalidArgument, ThinPoolParam)
 	}
 
-	// TODO: 优化 size 的获取方式
-	size := req.GetCapacityRange().RequiredBytes
+	cache, err := ParseCache(paras)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		// 缓存所在的 pv 属于某一个 vg, thin lv 不支持单独添加缓存
+		if len(paras[ThinPoolParam]) > 0 {
+			return nil, fmt.Errorf("%w: %s can't be used with %s", ErrInvalidArgument, CachePoolParam, ThinPoolParam)
+		}
+		if _, ok := paras[VGNameParam]; !ok {
+			return nil, fmt.Errorf("%w: %s requires %s", ErrInvalidArgument, CachePoolParam, VGNameParam)
+		}
+	}
+
+	// 优先使用 vgname, 没有指定时根据 vgpattern 和 vgpolicy 选择 vg
+	vgname, ok := paras[VGNameParam]
+	if !ok {
+		if _, ok := paras[VGPatternParam]; !ok {
+			klog.Info("create volume request sholud contain para of vgname or vgpattern")
+			return nil, fmt.Errorf("%w: miss vgname or vgpattern", ErrInvalidArgument)
+		}
+		// thin pool 属于某一个 vg, 不能在多个 vg 中选择
+		if len(paras[ThinPoolParam]) > 0 {
+			return nil, fmt.Errorf("%w: %s requires %s", ErrInvalidArgument, ThinPoolParam, VGNameParam)
+		}
+
+		if vgname, err = SelectVolumeGroup(paras, size, layout); err != nil {
+			return nil, err
+		}
+		klog.Infof("select vg %s for volume %s", vgname, name)
+	} else if !layout.IsLinear() {
+		if err = checkLayoutPVs(vgname, layout); err != nil {
+			return nil, err
+		}
+	}
 
 	path := filepath.Join(config.VolumeDir, vgname, name)
 
-	return &LogicalVolume{
-		Path:   path,
-		Name:   name,
-		VGName: vgname,
-		Size:   fmt.Sprint(size),
-	}, nil
-}
+	lv := &LogicalVolume{
+		Path:     path,
+		Name:     name,
+		VGName:   vgname,
+		Size:     size,
+		Tags:     NewVolumeTags(config.DriverName, name, paras),
+		ThinPool: paras[ThinPoolParam],
+		Layout:   layout,
+		Cache:    cache,
+	}
+	lv.Tags = append(lv.Tags, layout.Tags()...)
+	lv.Tags = append(lv.Tags, cache.Tags()...)
+	// 幂等重试时需要比较 thin pool 和数据源
+	lv.Tags = append(lv.Tags, ThinPoolTags(lv.ThinPool)...)
+	lv.Tags = append(lv.Tags, ContentSourceTags(req.GetVolumeContentSource())...)
 
-// 根据 DeleteVolumeRequest 生成 LV
-func NewLogicalVolumeForDelete(req *csi.DeleteVolumeRequest) (*LogicalVolume, error) {
-	name := req.GetVolumeId()
-	lv, err := lvsSet.getLVByName(name)
+	// 删除时没有参数, wipePolicy 需要记录在 tag 中
+	wipePolicy, err := ParseWipePolicy(paras)
 	if err != nil {
 		return nil, err
 	}
+	lv.Tags = append(lv.Tags, WipePolicyTags(wipePolicy)...)
+
+	forceDelete, err := ParseForceDelete(paras)
+	if err != nil {
+		return nil, err
+	}
+	lv.Tags = append(lv.Tags, ForceDeleteTags(forceDelete)...)
+
+	if len(lv.ThinPool) > 0 {
+		if lv.ThinPoolAutoCreate, lv.ThinPoolSizePercent, err = parseThinPoolAutoCreate(paras); err != nil {
+			return nil, err
+		}
+		if lv.OverprovisionRatio, err = OverprovisionRatio(paras, config.ThinPoolOverprovisionRatio); err != nil {
+			return nil, err
+		}
+		lv.Tags = append(lv.Tags, OverprovisionRatioTags(lv.OverprovisionRatio)...)
+	}
+
 	return lv, nil
 }
 
+// vg 中的 pv 数量需要满足布局的要求
+func checkLayoutPVs(vgname string, layout *Layout) error {
+	vg, err := GetVolumeGroup(vgname)
+	if err != nil {
+		return err
+	}
+	if vg == nil {
+		return fmt.Errorf("%w: %s", ErrVGNotFound, vgname)
+	}
+
+	if required := layout.RequiredPVs(); vg.PVCount < int64(required) {
+		return fmt.Errorf("%w: layout %v requires at least %d pvs, vg %s has %d", ErrInvalidArgument, layout.Args(), required, vgname, vg.PVCount)
+	}
+	return nil
+}
+
+// 根据 DeleteVolumeRequest 生成 LV, 通过 lvs 查找由 driver 创建的 lv, lv 不存在时返回 nil
+func NewLogicalVolumeForDelete(config *config.Config, req *csi.DeleteVolumeRequest) (*LogicalVolume, error) {
+	volumeID := req.GetVolumeId()
+	lvInfo, err := GetOwnedVolumeByID(config.DriverName, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if lvInfo == nil {
+		klog.Infof("lv doesn't exists, volume id: %s\n", volumeID)
+		return nil, nil
+	}
+
+	return &LogicalVolume{
+		Path:   lvInfo.Path,
+		Name:   lvInfo.Name,
+		VGName: lvInfo.VGName,
+		UUID:   lvInfo.UUID,
+		Size:   lvInfo.Size,
+	}, nil
+}
+
 // lvcreate -n test -L 5Gi lvmvg
+// lvcreate -n test -L 5Gi --type raid10 -i 2 -m 1 lvmvg
+// lvcreate -n test -V 5Gi -T lvmvg/pool
 func CreateLogicalVolume(lv *LogicalVolume) error {
 	// 构造 lvcreate 的命令
 	var createLVArg []string
 
 	if len(lv.Name) == 0 || len(lv.VGName) == 0 {
 		klog.Info("lvname and vgname can't be empty")
-		return errors.New("miss lvname or vgname")
+		return fmt.Errorf("%w: miss lvname or vgname", ErrInvalidArgument)
 	}
 
 	// TODO: 优化 size 的校验方式
-	if len(lv.Size) == 0 {
+	if lv.Size <= 0 {
 		klog.Info("lvsize can't be empty")
-		return errors.New("miss lvsize")
+		return fmt.Errorf("%w: miss lvsize", ErrInvalidArgument)
 	}
 
 	// lv 是否存在检查
@@ -130,72 +263,142 @@ func CreateLogicalVolume(lv *LogicalVolume) error {
 	}
 
 	if exist {
-		return errors.New("lv already exists")
+		return fmt.Errorf("%w: %s/%s", ErrLVExists, lv.VGName, lv.Name)
 	}
 
 	createLVArg = append(createLVArg, "-n", lv.Name)
-	createLVArg = append(createLVArg, "-L", lv.Size)
-	createLVArg = append(createLVArg, lv.VGName)
+	// 不带单位时 lvm 默认以 MiB 为单位, 这里显式指定为 byte
+	if len(lv.ThinPool) > 0 {
+		// thin lv 使用 -V 指定虚拟容量
+		createLVArg = append(createLVArg, "-V", fmt.Sprintf("%db", lv.Size), "-T", lv.VGName+"/"+lv.ThinPool)
+	} else {
+		createLVArg = append(createLVArg, "-L", fmt.Sprintf("%db", lv.Size))
+		if !lv.Layout.IsLinear() {
+			createLVArg = append(createLVArg, lv.Layout.Args()...)
+		}
+	}
+	for _, tag := range lv.Tags {
+		createLVArg = append(createLVArg, "--addtag", tag)
+	}
+	if len(lv.ThinPool) == 0 {
+		createLVArg = append(createLVArg, lv.VGName)
+	}
 
-	exec := exec.New()
-	out, err := exec.Command(lvCreate, createLVArg...).CombinedOutput()
-	if err != nil {
-		klog.Infof("lvcreate failed, lvname: %s, vgname: %s, size: %v\n", lv.Name, lv.VGName, lv.Size)
-		return errors.New("lvcreate failed")
+	create := func() error {
+		out, err := runCommand(lvCreate, createLVArg...)
+		if err != nil {
+			klog.Infof("lvcreate failed, lvname: %s, vgname: %s, size: %v\n", lv.Name, lv.VGName, lv.Size)
+			return err
+		}
+
+		klog.Info(string(out))
+		return nil
 	}
 
-	// 数据归档入 lvsSet
-	lvsSet.addLV(lv)
+	if len(lv.ThinPool) > 0 {
+		return withThinPool(lv, create)
+	}
+	if err := create(); err != nil {
+		return err
+	}
 
-	klog.Info(string(out))
+	// 添加缓存失败时删除新建的 lv, 避免重试时返回没有缓存的 lv
+	if lv.Cache != nil {
+		if err := attachCache(lv.VGName, lv.Name, lv.Size, lv.Cache); err != nil {
+			if _, removeErr := runCommand(lvRemove, "-f", lv.VGName+"/"+lv.Name); removeErr != nil {
+				klog.Errorf("cleanup lv %s after attaching cache failure failed: %v", lv.Name, removeErr)
+			}
+			if removeErr := removeDetachedCache(lv.VGName, lv.Name); removeErr != nil {
+				klog.Errorf("cleanup cache of lv %s failed: %v", lv.Name, removeErr)
+			}
+			return err
+		}
+	}
 	return nil
 }
 
+// CheckVolumeExists 通过 lvs 检查 lv 是否存在, 未激活的 lv 没有设备文件, 不能通过设备路径判断
 func CheckVolumeExists(lv *LogicalVolume) (bool, error) {
-	if _, err := os.Stat(lv.Path); err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		}
+	lvInfo, err := GetLogicalVolume(lv.VGName, lv.Name)
+	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return lvInfo != nil, nil
 }
 
 // lvremove /dev/lvmvg/test -f
+// lv 仍在使用时返回 ErrInUse, 普通 lv 还有 snapshot 时返回 ErrHasSnapshots
+// 指定了 wipePolicy 时先在后台清除数据, 清除完成前返回 ErrBusy
 func RemoveLogicalVolume(lv *LogicalVolume) error {
+	return removeLogicalVolume(lv, false)
+}
+
+// wait 为 true 时同步清除数据后再删除
+func removeLogicalVolume(lv *LogicalVolume, wait bool) error {
 	// 构造 lvremove 的命令
 	var removeLVArg []string
 
 	// 删除前预检查
-	// TODO: 检查 lv 是否还是被 mount 的，可能存在误删除的情况，这里进行维护
 	if len(lv.Name) == 0 {
 		klog.Info("lvname can't be empty")
-		return errors.New("miss lvname")
+		return fmt.Errorf("%w: miss lvname", ErrInvalidArgument)
 	}
 
 	// lv 是否存在检查
-	exist, err := CheckVolumeExists(lv)
+	lvInfo, err := GetLogicalVolume(lv.VGName, lv.Name)
 	if err != nil {
 		return err
 	}
 
-	if !exist {
-		return errors.New("lv doesn't exists")
+	// lv 已经不存在时视为删除成功, 保证幂等性
+	if lvInfo == nil {
+		klog.Infof("lv doesn't exists, lvname: %s\n", lv.Name)
+		return nil
+	}
+
+	// 需要在清除数据之前检查, 清除数据会写满 COW snapshot 的空间
+	if err := checkNoSnapshots(lvInfo); err != nil {
+		return err
+	}
+
+	if err := prepareRemove(lvInfo, wait); err != nil {
+		return err
+	}
+
+	// 清除数据时会拆下并删除缓存, 重新查询 lv 的状态
+	if lvInfo.WipePolicy() != WipePolicyNone {
+		if lvInfo, err = GetLogicalVolume(lv.VGName, lv.Name); err != nil || lvInfo == nil {
+			return err
+		}
+	}
+
+	// 先刷回缓存中的脏数据并删除缓存, 再删除 lv
+	if lvInfo.IsCached() {
+		if err := detachCache(lv.VGName, lv.Name, false); err != nil {
+			return err
+		}
 	}
 
 	removeLVArg = append(removeLVArg, lv.Path)
 	removeLVArg = append(removeLVArg, "-f")
 
-	exec := exec.New()
-	out, err := exec.Command(lvRemove, removeLVArg...).CombinedOutput()
+	out, err := runCommand(lvRemove, removeLVArg...)
 	if err != nil {
 		klog.Infof("lvremove failed, lvname: %s, vgname: %s, size: %v\n", lv.Name, lv.VGName, lv.Size)
-		return errors.New("lvremove failed")
+		return err
 	}
+	klog.Info(string(out))
 
-	// 数据归档入 lvsSet
-	lvsSet.deleteLV(lv)
+	// 从数据源拷贝中断时可能遗留临时 snapshot
+	if _, ok := lvInfo.TagValue(contentSourceTagPrefix); ok {
+		if err := removeCloneSnapshot(lv.Name); err != nil {
+			return err
+		}
+	}
 
-	klog.Info(string(out))
+	// 扩容中断时缓存 lv 可能处于拆下的状态
+	if lvInfo.Cache() != nil {
+		return removeDetachedCache(lv.VGName, lv.Name)
+	}
 	return nil
 }
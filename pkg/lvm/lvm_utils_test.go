@@ -10,7 +10,7 @@ var (
 		Path:   "/dev/lvmvg/test",
 		Name:   "test",
 		VGName: "lvmvg",
-		Size:   "5Gi",
+		Size:   5 * 1024 * 1024 * 1024,
 	}
 )
 
@@ -0,0 +1,215 @@
+package lvm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lvs 输出的字段
+var lvsFields = []string{
+	"lv_name",
+	"vg_name",
+	"lv_uuid",
+	"lv_path",
+	"lv_size",
+	"lv_attr",
+	"lv_tags",
+	"origin",
+	"origin_size",
+	"pool_lv",
+	"lv_time",
+	"data_percent",
+}
+
+// lv_attr 中各位的含义, 详见 man lvs
+const (
+	lvAttrTypeIndex   = 0
+	lvAttrStateIndex  = 4
+	lvAttrOpenIndex   = 5
+	lvAttrTargetIndex = 6
+	lvAttrHealthIndex = 8
+)
+
+// lvs 输出中 lv_time 的格式
+const lvTimeLayout = "2006-01-02 15:04:05 -0700"
+
+// LVInfo 为 lvs 查询到的 lv 信息
+type LVInfo struct {
+	Name        string
+	VGName      string
+	UUID        string
+	Path        string
+	Size        int64
+	Attr        string
+	Tags        []string
+	Origin      string
+	OriginSize  int64
+	PoolLV      string
+	CreateTime  time.Time
+	DataPercent float64
+}
+
+// IsThin lv 是否位于 thin pool 中, 挂了缓存的 lv 的 pool_lv 为缓存 lv
+func (lv *LVInfo) IsThin() bool {
+	return len(lv.PoolLV) > 0 && !lv.IsCached()
+}
+
+// IsCached lv 是否挂了 dm-cache 或者 dm-writecache, lv_attr 的第七位为 C
+func (lv *LVInfo) IsCached() bool {
+	return lv.attrAt(lvAttrTargetIndex) == 'C'
+}
+
+// IsThinPool lv 是否为 thin pool, lv_attr 的第一位为 t
+func (lv *LVInfo) IsThinPool() bool {
+	return lv.attrAt(lvAttrTypeIndex) == 't'
+}
+
+// IsActive lv 是否已激活, lv_attr 的第五位为 a
+func (lv *LVInfo) IsActive() bool {
+	return lv.attrAt(lvAttrStateIndex) == 'a'
+}
+
+// IsOpen lv 的设备是否被打开 (例如被挂载), lv_attr 的第六位为 o
+func (lv *LVInfo) IsOpen() bool {
+	return lv.attrAt(lvAttrOpenIndex) == 'o'
+}
+
+// 返回 lv_attr 中指定位置的字符, 不存在时返回 0
+func (lv *LVInfo) attrAt(i int) byte {
+	if i >= len(lv.Attr) {
+		return 0
+	}
+	return lv.Attr[i]
+}
+
+// HasTag lv 是否带有指定的 tag
+func (lv *LVInfo) HasTag(tag string) bool {
+	for _, t := range lv.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type lvsReport struct {
+	Report []struct {
+		LV []map[string]string `json:"lv"`
+	} `json:"report"`
+}
+
+// ListLogicalVolumes 查询 vg 中的所有 lv, vgname 为空时查询所有 vg
+// lvs --reportformat json --units b --nosuffix -o lv_name,vg_name,... lvmvg
+func ListLogicalVolumes(vgname string) ([]*LVInfo, error) {
+	lvsArg := []string{
+		"--reportformat", "json",
+		"--units", "b",
+		"--nosuffix",
+		"-o", strings.Join(lvsFields, ","),
+	}
+	if len(vgname) > 0 {
+		lvsArg = append(lvsArg, vgname)
+	}
+
+	out, err := runReportCommand(lvs, lvsArg...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLVsReport(out)
+}
+
+func parseLVsReport(out []byte) ([]*LVInfo, error) {
+	var report lvsReport
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("decode lvs report failed: %v", err)
+	}
+
+	var lvInfos []*LVInfo
+	for _, r := range report.Report {
+		for _, fields := range r.LV {
+			lv, err := parseLVInfo(fields)
+			if err != nil {
+				return nil, err
+			}
+			lvInfos = append(lvInfos, lv)
+		}
+	}
+
+	return lvInfos, nil
+}
+
+func parseLVInfo(fields map[string]string) (*LVInfo, error) {
+	lv := &LVInfo{
+		Name:   fields["lv_name"],
+		VGName: fields["vg_name"],
+		UUID:   fields["lv_uuid"],
+		Path:   fields["lv_path"],
+		Attr:   fields["lv_attr"],
+		Origin: fields["origin"],
+		PoolLV: fields["pool_lv"],
+	}
+
+	var err error
+	if lv.Size, err = parseSize(fields["lv_size"]); err != nil {
+		return nil, fmt.Errorf("parse lv_size of lv %s failed: %v", lv.Name, err)
+	}
+	if lv.OriginSize, err = parseSize(fields["origin_size"]); err != nil {
+		return nil, fmt.Errorf("parse origin_size of lv %s failed: %v", lv.Name, err)
+	}
+
+	if tags := fields["lv_tags"]; len(tags) > 0 {
+		lv.Tags = strings.Split(tags, ",")
+	}
+
+	if t := fields["lv_time"]; len(t) > 0 {
+		if lv.CreateTime, err = time.Parse(lvTimeLayout, t); err != nil {
+			return nil, fmt.Errorf("parse lv_time of lv %s failed: %v", lv.Name, err)
+		}
+	}
+
+	if p := fields["data_percent"]; len(p) > 0 {
+		if lv.DataPercent, err = strconv.ParseFloat(p, 64); err != nil {
+			return nil, fmt.Errorf("parse data_percent of lv %s failed: %v", lv.Name, err)
+		}
+	}
+
+	return lv, nil
+}
+
+// 解析 --units b --nosuffix 输出的大小, 空字符串视为 0
+func parseSize(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// GetLogicalVolume 根据名称查找 lv, vgname 为空时在所有 vg 中查找, 不存在时返回 nil
+func GetLogicalVolume(vgname, name string) (*LVInfo, error) {
+	lvInfos, err := ListLogicalVolumes(vgname)
+	if err != nil {
+		// vg 不存在时 lv 也不存在
+		if errors.Is(err, ErrVGNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var found *LVInfo
+	for _, lv := range lvInfos {
+		if lv.Name != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("lv %s exists in multiple vgs: %s, %s", name, found.VGName, lv.VGName)
+		}
+		found = lv
+	}
+
+	return found, nil
+}
@@ -0,0 +1,149 @@
+package lvm
+
+import (
+	"testing"
+)
+
+const testLVsReport = `{
+      "report": [
+          {
+              "lv": [
+                  {"lv_name":"pvc-1", "vg_name":"lvmvg", "lv_uuid":"u1", "lv_path":"/dev/lvmvg/pvc-1", "lv_size":"4294967296", "lv_attr":"-wi-a-----", "lv_tags":"", "origin":"", "origin_size":"", "pool_lv":"", "lv_time":"2023-06-01 10:00:00 +0800", "data_percent":""},
+                  {"lv_name":"snap-1", "vg_name":"lvmvg", "lv_uuid":"u2", "lv_path":"/dev/lvmvg/snap-1", "lv_size":"1073741824", "lv_attr":"swi-a-s---", "lv_tags":"csi-snapshot,foo", "origin":"pvc-1", "origin_size":"4294967296", "pool_lv":"", "lv_time":"2023-06-01 11:00:00 +0800", "data_percent":"0.01"}
+              ]
+          }
+      ]
+  }`
+
+func TestParseLVsReport(t *testing.T) {
+	lvInfos, err := parseLVsReport([]byte(testLVsReport))
+	if err != nil {
+		t.Fatalf("parse lvs report failed: %v", err)
+	}
+	if len(lvInfos) != 2 {
+		t.Fatalf("expected 2 lvs, got %d", len(lvInfos))
+	}
+
+	lv := lvInfos[0]
+	if lv.Name != "pvc-1" || lv.VGName != "lvmvg" || lv.Size != 4294967296 {
+		t.Errorf("unexpected lv: %+v", lv)
+	}
+	if lv.IsThin() || IsSnapshot(lv) {
+		t.Errorf("lv %s should be a thick volume", lv.Name)
+	}
+
+	snap := lvInfos[1]
+	if !IsSnapshot(snap) || snap.OriginSize != 4294967296 || snap.DataPercent != 0.01 {
+		t.Errorf("unexpected snapshot: %+v", snap)
+	}
+	if snap.CreateTime.Unix() != 1685588400 {
+		t.Errorf("unexpected create time: %v", snap.CreateTime)
+	}
+	if SnapshotID(snap) != "lvmvg/snap-1" {
+		t.Errorf("unexpected snapshot id: %s", SnapshotID(snap))
+	}
+	// 旧版本的 snapshot 没有记录 source, 使用 origin
+	if source := snap.SnapshotSource(); source != "lvmvg/pvc-1" {
+		t.Errorf("unexpected snapshot source: %s", source)
+	}
+}
+
+func TestParseSnapshotID(t *testing.T) {
+	vg, name, err := ParseSnapshotID("lvmvg/snap-1")
+	if err != nil || vg != "lvmvg" || name != "snap-1" {
+		t.Errorf("unexpected result: %s %s %v", vg, name, err)
+	}
+	// 兼容包含 origin 的旧 id
+	vg, name, err = ParseSnapshotID("lvmvg/pvc-1/snap-1")
+	if err != nil || vg != "lvmvg" || name != "snap-1" {
+		t.Errorf("unexpected result of legacy id: %s %s %v", vg, name, err)
+	}
+
+	for _, id := range []string{"", "lvmvg", "lvmvg/", "lvmvg//snap-1", "a/b/c/d"} {
+		if _, _, err := ParseSnapshotID(id); err == nil {
+			t.Errorf("expected error for snapshot id %q", id)
+		}
+	}
+}
+
+func TestSnapshotsOf(t *testing.T) {
+	lvInfos, err := parseLVsReport([]byte(testLVsReport))
+	if err != nil {
+		t.Fatalf("parse lvs report failed: %v", err)
+	}
+
+	origin, snap := lvInfos[0], lvInfos[1]
+	if names := snapshotsOf(origin, lvInfos); len(names) != 1 || names[0] != snap.Name {
+		t.Errorf("unexpected snapshots of %s: %v", origin.Name, names)
+	}
+	if names := snapshotsOf(snap, lvInfos); len(names) > 0 {
+		t.Errorf("unexpected snapshots of %s: %v", snap.Name, names)
+	}
+	// 其他 vg 中的同名 origin 不是 lv 的 snapshot
+	other := &LVInfo{Name: "pvc-1", VGName: "othervg"}
+	if names := snapshotsOf(other, lvInfos); len(names) > 0 {
+		t.Errorf("unexpected snapshots of %s/%s: %v", other.VGName, other.Name, names)
+	}
+}
+
+func TestSnapshotWithoutOrigin(t *testing.T) {
+	// thin origin 被删除后 lvs 中的 origin 为空, 仍然通过 tag 识别为 snapshot
+	snap := &LVInfo{Name: "snap-1", VGName: "lvmvg", Attr: "Vwi---tz-k", PoolLV: "pool", Tags: NewSnapshotTags("csidriver.whou.io", "snapshot-1", "lvmvg/pvc-1")}
+	if !IsSnapshot(snap) {
+		t.Errorf("lv %s should be a snapshot without origin", snap.Name)
+	}
+	if SnapshotID(snap) != "lvmvg/snap-1" || snap.SnapshotSource() != "lvmvg/pvc-1" {
+		t.Errorf("unexpected snapshot id %s or source %s", SnapshotID(snap), snap.SnapshotSource())
+	}
+	if !IsSnapshotOf(snap, "lvmvg/pvc-1") || IsSnapshotOf(snap, "lvmvg/pvc-2") {
+		t.Errorf("unexpected IsSnapshotOf result without origin")
+	}
+}
+
+func TestSnapshotLVName(t *testing.T) {
+	if name := SnapshotLVName("snapshot-abc"); name != "snap-abc" {
+		t.Errorf("unexpected lv name: %s", name)
+	}
+	if name := SnapshotLVName("mysnap"); name != "mysnap" {
+		t.Errorf("unexpected lv name: %s", name)
+	}
+}
+
+const testVGsReport = `{
+      "report": [
+          {
+              "vg": [
+                  {"vg_name":"lvmvg", "vg_uuid":"u1", "vg_size":"10733223936", "vg_free":"6438256640", "vg_extent_size":"4194304", "vg_extent_count":"2559", "vg_free_count":"1535", "pv_count":"1", "lv_count":"1", "vg_tags":""}
+              ]
+          }
+      ]
+  }`
+
+func TestParseVGsReport(t *testing.T) {
+	vgInfos, err := parseVGsReport([]byte(testVGsReport))
+	if err != nil {
+		t.Fatalf("parse vgs report failed: %v", err)
+	}
+	if len(vgInfos) != 1 {
+		t.Fatalf("expected 1 vg, got %d", len(vgInfos))
+	}
+
+	vg := vgInfos[0]
+	if vg.Name != "lvmvg" || vg.Free != 6438256640 || vg.ExtentSize != 4194304 || vg.PVCount != 1 {
+		t.Errorf("unexpected vg: %+v", vg)
+	}
+}
+
+func TestRoundUpToExtent(t *testing.T) {
+	extent := int64(4 * 1024 * 1024)
+	cases := map[int64]int64{
+		1:          extent,
+		extent:     extent,
+		extent + 1: 2 * extent,
+	}
+	for size, expected := range cases {
+		if got := RoundUpToExtent(size, extent); got != expected {
+			t.Errorf("round up %d: expected %d, got %d", size, expected, got)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package lvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/houwenchen/kubernetes-csi/pkg/config"
+	"github.com/houwenchen/kubernetes-csi/pkg/mount"
+	"k8s.io/klog/v2"
+)
+
+/*
+node 启动时根据配置的设备自动创建或者扩容 vg, 代替手动执行 pvcreate/vgcreate
+1. 通过 lsblk 查找候选设备, 按设备路径 glob 和过滤条件筛选
+root@master:~# lsblk -J -b -p -l -o NAME,TYPE,SIZE,RO,MOUNTPOINT,PKNAME
+2. 已经属于目标 vg 的 pv 跳过, 属于其他 vg 的 pv 不处理
+3. 有文件系统或者分区表等签名的设备默认不处理, 指定 force 时覆盖
+root@master:~# pvcreate -y /dev/sdb
+4. vg 不存在时创建, 存在时扩容
+root@master:~# vgcreate lvmvg /dev/sdb
+root@master:~# vgextend lvmvg /dev/sdc
+*/
+
+// 自动创建 vg 所需命令
+const (
+	lsblk    string = "lsblk"
+	pvCreate string = "pvcreate"
+	vgCreate string = "vgcreate"
+	vgExtend string = "vgextend"
+)
+
+// BlockDevice 为 lsblk 查询到的块设备
+type BlockDevice struct {
+	Name       string
+	Type       string
+	Size       int64
+	ReadOnly   bool
+	MountPoint string
+	// 父设备, 分区的父设备为所在的磁盘
+	Parent string
+}
+
+// 设备过滤条件, 配置格式为逗号分隔的 unmounted, nopartitions, type=disk, size>10Gi, size<=1Ti
+type deviceFilter struct {
+	unmounted    bool
+	noPartitions bool
+	types        []string
+	minSize      int64
+	maxSize      int64
+}
+
+func parseDeviceFilter(filters []string) (*deviceFilter, error) {
+	f := &deviceFilter{}
+	for _, item := range filters {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "unmounted":
+			f.unmounted = true
+		case item == "nopartitions":
+			f.noPartitions = true
+		case strings.HasPrefix(item, "type="):
+			f.types = append(f.types, strings.TrimPrefix(item, "type="))
+		case strings.HasPrefix(item, "size"):
+			if err := f.parseSizeFilter(strings.TrimPrefix(item, "size")); err != nil {
+				return nil, fmt.Errorf("invalid device filter %q: %v", item, err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown device filter %q", item)
+		}
+	}
+	return f, nil
+}
+
+// 解析 >10Gi, >=10Gi, <1Ti, <=1Ti
+// 允许运算符前后有空格, 如 "size > 10Gi"
+func (f *deviceFilter) parseSizeFilter(s string) error {
+	s = strings.TrimSpace(s)
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+
+		size, err := parseByteSize(strings.TrimPrefix(s, op))
+		if err != nil {
+			return err
+		}
+		switch op {
+		case ">=":
+			f.minSize = size
+		case ">":
+			f.minSize = size + 1
+		case "<=":
+			f.maxSize = size
+		case "<":
+			f.maxSize = size - 1
+		}
+		return nil
+	}
+	return fmt.Errorf("expected one of >, >=, <, <=")
+}
+
+// 解析带单位的大小, K/M/G/T 为 1000 进制, Ki/Mi/Gi/Ti 为 1024 进制, 不带单位时为 byte
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	}
+
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSuffix(s, u.suffix), u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * factor, nil
+}
+
+// 设备是否满足过滤条件, children 为设备的分区等子设备
+func (f *deviceFilter) match(dev *BlockDevice, children []*BlockDevice) bool {
+	if f.noPartitions && len(children) > 0 {
+		return false
+	}
+	if f.unmounted {
+		if len(dev.MountPoint) > 0 {
+			return false
+		}
+		for _, child := range children {
+			if len(child.MountPoint) > 0 {
+				return false
+			}
+		}
+	}
+	if len(f.types) > 0 {
+		matched := false
+		for _, t := range f.types {
+			if t == dev.Type {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.minSize > 0 && dev.Size < f.minSize {
+		return false
+	}
+	if f.maxSize > 0 && dev.Size > f.maxSize {
+		return false
+	}
+	return true
+}
+
+// 没有配置任何过滤条件
+func (f *deviceFilter) empty() bool {
+	return !f.unmounted && !f.noPartitions && len(f.types) == 0 && f.minSize == 0 && f.maxSize == 0
+}
+
+// ListBlockDevices 通过 lsblk 查询所有块设备
+// lsblk -J -b -p -l -o NAME,TYPE,SIZE,RO,MOUNTPOINT,PKNAME
+func ListBlockDevices() ([]*BlockDevice, error) {
+	out, err := runReportCommand(lsblk, "-J", "-b", "-p", "-l", "-o", "NAME,TYPE,SIZE,RO,MOUNTPOINT,PKNAME")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLsblkReport(out)
+}
+
+func parseLsblkReport(out []byte) ([]*BlockDevice, error) {
+	var report struct {
+		BlockDevices []map[string]interface{} `json:"blockdevices"`
+	}
+
+	// 不同版本的 lsblk 中 size 和 ro 可能是数字/布尔值或者字符串
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	decoder.UseNumber()
+	if err := decoder.Decode(&report); err != nil {
+		return nil, fmt.Errorf("decode lsblk report failed: %v", err)
+	}
+
+	var devices []*BlockDevice
+	for _, fields := range report.BlockDevices {
+		dev := &BlockDevice{
+			Name:       lsblkString(fields["name"]),
+			Type:       lsblkString(fields["type"]),
+			MountPoint: lsblkString(fields["mountpoint"]),
+			Parent:     lsblkString(fields["pkname"]),
+		}
+
+		ro := lsblkString(fields["ro"])
+		dev.ReadOnly = ro == "1" || ro == "true"
+
+		var err error
+		if dev.Size, err = parseSize(lsblkString(fields["size"])); err != nil {
+			return nil, fmt.Errorf("parse size of device %s failed: %v", dev.Name, err)
+		}
+		devices = append(devices, dev)
+	}
+
+	return devices, nil
+}
+
+func lsblkString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// 自动创建 vg 的计划
+type provisionPlan struct {
+	// 需要执行 pvcreate 的设备
+	create []string
+	// 需要加入 vg 的设备, 包括 create 中的设备和已经是 pv 但不属于任何 vg 的设备
+	add []string
+}
+
+// 根据设备和 pv 的状态生成计划, pvVGs 为 pv 到所属 vg 的映射, signatureOf 返回设备上已有的签名
+func planProvision(devices []*BlockDevice, pvVGs map[string]string, vgname string, globs []string, filter *deviceFilter,
+	force bool, signatureOf func(string) (string, error)) (*provisionPlan, error) {
+	// 避免误用节点上的所有空白设备, 必须通过 glob 或者过滤条件明确指定设备
+	if len(globs) == 0 && filter.empty() {
+		return nil, fmt.Errorf("no device glob or filter is specified to provision vg %s", vgname)
+	}
+
+	children := make(map[string][]*BlockDevice)
+	for _, dev := range devices {
+		if len(dev.Parent) > 0 {
+			children[dev.Parent] = append(children[dev.Parent], dev)
+		}
+	}
+
+	plan := &provisionPlan{}
+	// 已经加入计划的设备, 磁盘和它的分区只能有一个作为 pv
+	planned := make(map[string]bool)
+	for _, dev := range devices {
+		matched, err := matchDeviceGlobs(dev.Name, globs)
+		if err != nil {
+			return nil, err
+		}
+		if !matched || !filter.match(dev, children[dev.Name]) {
+			continue
+		}
+		if overlap, ok := plannedOverlap(dev, children, planned); ok {
+			klog.Warningf("device %s overlaps with planned device %s, skip it", dev.Name, overlap)
+			continue
+		}
+
+		if vg, ok := pvVGs[dev.Name]; ok {
+			switch vg {
+			case vgname:
+				klog.V(4).Infof("device %s is already a pv of vg %s", dev.Name, vgname)
+			case "":
+				plan.add = append(plan.add, dev.Name)
+				planned[dev.Name] = true
+			default:
+				klog.Warningf("device %s belongs to vg %s, skip it", dev.Name, vg)
+			}
+			continue
+		}
+
+		// 已挂载、只读或者正在被使用的设备即使指定 force 也不处理
+		if inUse, reason := deviceInUse(dev, children, pvVGs); inUse {
+			klog.Warningf("device %s %s, skip it", dev.Name, reason)
+			continue
+		}
+
+		signature, err := signatureOf(dev.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(signature) > 0 {
+			if !force {
+				klog.Warningf("device %s has existing %s signature, skip it, set force to overwrite", dev.Name, signature)
+				continue
+			}
+			klog.Warningf("device %s has existing %s signature, overwrite it by force", dev.Name, signature)
+		}
+
+		plan.create = append(plan.create, dev.Name)
+		plan.add = append(plan.add, dev.Name)
+		planned[dev.Name] = true
+	}
+
+	return plan, nil
+}
+
+// 返回已经加入计划的父设备或者子设备
+func plannedOverlap(dev *BlockDevice, children map[string][]*BlockDevice, planned map[string]bool) (string, bool) {
+	if planned[dev.Parent] {
+		return dev.Parent, true
+	}
+	for _, child := range children[dev.Name] {
+		if planned[child.Name] {
+			return child.Name, true
+		}
+	}
+	return "", false
+}
+
+// lv 本身不能作为 pv, 分区已经是 pv 或者有分区以外的子设备 (例如 lv, dm-crypt) 时说明设备正在被使用
+func deviceInUse(dev *BlockDevice, children map[string][]*BlockDevice, pvVGs map[string]string) (bool, string) {
+	switch {
+	case dev.Type == "lvm":
+		return true, "is a logical volume"
+	case len(dev.MountPoint) > 0:
+		return true, "is mounted"
+	case dev.ReadOnly:
+		return true, "is read only"
+	}
+
+	for _, child := range children[dev.Name] {
+		if child.Type != "part" {
+			return true, fmt.Sprintf("is held by %s", child.Name)
+		}
+		if _, ok := pvVGs[child.Name]; ok {
+			return true, fmt.Sprintf("has partition %s used as pv", child.Name)
+		}
+		if inUse, reason := deviceInUse(child, children, pvVGs); inUse {
+			return true, fmt.Sprintf("has partition %s which %s", child.Name, reason)
+		}
+	}
+	return false, ""
+}
+
+// 设备路径是否匹配任一 glob, globs 为空时只使用过滤条件筛选
+func matchDeviceGlobs(name string, globs []string) (bool, error) {
+	if len(globs) == 0 {
+		return true, nil
+	}
+	for _, glob := range globs {
+		matched, err := filepath.Match(glob, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid device glob %q: %v", glob, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ProvisionVolumeGroup 使用配置中匹配的设备创建或者扩容 vg, 没有配置 vg 时不做任何操作
+func ProvisionVolumeGroup(config *config.Config) error {
+	vgname := config.ProvisionVGName
+	if len(vgname) == 0 {
+		return nil
+	}
+
+	filter, err := parseDeviceFilter(config.ProvisionFilters)
+	if err != nil {
+		return err
+	}
+
+	devices, err := ListBlockDevices()
+	if err != nil {
+		return err
+	}
+
+	// 通过 pv 的分段找到 pv 所属的 vg, 不属于任何 vg 的 pv 的 vg_name 为空
+	segments, err := ListPVSegments("")
+	if err != nil {
+		return err
+	}
+	pvVGs := make(map[string]string, len(segments))
+	for _, seg := range segments {
+		pvVGs[seg.PVName] = seg.VGName
+	}
+
+	plan, err := planProvision(devices, pvVGs, vgname, config.ProvisionDevices, filter, config.ProvisionForce, mount.GetDiskFormat)
+	if err != nil {
+		return err
+	}
+
+	vg, err := GetVolumeGroup(vgname)
+	if err != nil {
+		return err
+	}
+	if len(plan.add) == 0 {
+		if vg == nil {
+			klog.Warningf("no available device to create vg %s", vgname)
+		}
+		return nil
+	}
+
+	for _, dev := range plan.create {
+		pvcreateArg := []string{"-y"}
+		if config.ProvisionForce {
+			pvcreateArg = append(pvcreateArg, "-ff")
+		}
+		out, err := runCommand(pvCreate, append(pvcreateArg, dev)...)
+		if err != nil {
+			klog.Infof("pvcreate failed, device: %s\n", dev)
+			return err
+		}
+		klog.Info(string(out))
+	}
+
+	cmd := vgExtend
+	if vg == nil {
+		cmd = vgCreate
+	}
+	out, err := runCommand(cmd, append([]string{vgname}, plan.add...)...)
+	if err != nil {
+		klog.Infof("%s failed, vgname: %s, devices: %v\n", cmd, vgname, plan.add)
+		return err
+	}
+
+	klog.Info(string(out))
+	klog.Infof("vg %s is provisioned with devices %v", vgname, plan.add)
+	return nil
+}
@@ -0,0 +1,151 @@
+package lvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testLsblkReport = `{
+   "blockdevices": [
+      {"name":"/dev/sda", "type":"disk", "size":68719476736, "ro":false, "mountpoint":null, "pkname":null},
+      {"name":"/dev/sda1", "type":"part", "size":1073741824, "ro":false, "mountpoint":"/boot/efi", "pkname":"/dev/sda"},
+      {"name":"/dev/sdb", "type":"disk", "size":"107374182400", "ro":"0", "mountpoint":null, "pkname":null},
+      {"name":"/dev/sdc", "type":"disk", "size":107374182400, "ro":false, "mountpoint":null, "pkname":null},
+      {"name":"/dev/sdd", "type":"disk", "size":107374182400, "ro":false, "mountpoint":null, "pkname":null},
+      {"name":"/dev/sde", "type":"disk", "size":107374182400, "ro":false, "mountpoint":null, "pkname":null},
+      {"name":"/dev/sdf", "type":"disk", "size":5368709120, "ro":false, "mountpoint":null, "pkname":null},
+      {"name":"/dev/sdg", "type":"disk", "size":107374182400, "ro":false, "mountpoint":null, "pkname":null},
+      {"name":"/dev/mapper/datavg-data", "type":"lvm", "size":107374182400, "ro":false, "mountpoint":"/data", "pkname":"/dev/sdg"},
+      {"name":"/dev/sr0", "type":"rom", "size":1073741824, "ro":true, "mountpoint":null, "pkname":null}
+   ]
+}`
+
+func TestParseLsblkReport(t *testing.T) {
+	devices, err := parseLsblkReport([]byte(testLsblkReport))
+	if err != nil {
+		t.Fatalf("parse lsblk report failed: %v", err)
+	}
+	if len(devices) != 10 {
+		t.Fatalf("expected 10 devices, got %d", len(devices))
+	}
+
+	// 不同版本的 lsblk 中 size 和 ro 可能是字符串
+	sdb := devices[2]
+	if sdb.Name != "/dev/sdb" || sdb.Size != 107374182400 || sdb.ReadOnly || len(sdb.MountPoint) > 0 {
+		t.Errorf("unexpected device: %+v", sdb)
+	}
+	if sda1 := devices[1]; sda1.Parent != "/dev/sda" || sda1.MountPoint != "/boot/efi" {
+		t.Errorf("unexpected partition: %+v", sda1)
+	}
+	if sr0 := devices[9]; !sr0.ReadOnly {
+		t.Errorf("device %s should be read only", sr0.Name)
+	}
+}
+
+func TestParseDeviceFilter(t *testing.T) {
+	filter, err := parseDeviceFilter([]string{"unmounted", "nopartitions", "type=disk", "size>=10Gi", "size<1T"})
+	if err != nil {
+		t.Fatalf("parse device filter failed: %v", err)
+	}
+	want := &deviceFilter{unmounted: true, noPartitions: true, types: []string{"disk"}, minSize: 10 << 30, maxSize: 1e12 - 1}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("expected %+v, got %+v", want, filter)
+	}
+
+	// 运算符前后可以有空格
+	filter, err = parseDeviceFilter([]string{"size > 10Gi", "size <= 1Ti "})
+	if err != nil {
+		t.Fatalf("parse device filter failed: %v", err)
+	}
+	want = &deviceFilter{minSize: 10<<30 + 1, maxSize: 1 << 40}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("expected %+v, got %+v", want, filter)
+	}
+
+	for _, filters := range [][]string{{"mounted"}, {"size=10Gi"}, {"size>10X"}} {
+		if _, err := parseDeviceFilter(filters); err == nil {
+			t.Errorf("expected error for filters %v", filters)
+		}
+	}
+}
+
+func TestPlanProvision(t *testing.T) {
+	devices, err := parseLsblkReport([]byte(testLsblkReport))
+	if err != nil {
+		t.Fatalf("parse lsblk report failed: %v", err)
+	}
+
+	pvVGs := map[string]string{"/dev/sdc": "lvmvg", "/dev/sdd": "", "/dev/sdg": "datavg"}
+	signatures := map[string]string{"/dev/sda": "gpt", "/dev/sde": "ext4"}
+	signatureOf := func(dev string) (string, error) { return signatures[dev], nil }
+
+	filter, err := parseDeviceFilter([]string{"type=disk", "size>10Gi"})
+	if err != nil {
+		t.Fatalf("parse device filter failed: %v", err)
+	}
+
+	// sdc 已经在 vg 中, sdd 是不属于任何 vg 的 pv, sde 有文件系统, sdf 太小, sdg 属于其他 vg
+	plan, err := planProvision(devices, pvVGs, "lvmvg", []string{"/dev/sd*"}, filter, false, signatureOf)
+	if err != nil {
+		t.Fatalf("plan provision failed: %v", err)
+	}
+	if !reflect.DeepEqual(plan.create, []string{"/dev/sdb"}) || !reflect.DeepEqual(plan.add, []string{"/dev/sdb", "/dev/sdd"}) {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+
+	// force 时覆盖 sde 上的文件系统, sda 的分区已挂载, 不能覆盖
+	plan, err = planProvision(devices, pvVGs, "lvmvg", nil, filter, true, signatureOf)
+	if err != nil {
+		t.Fatalf("plan provision failed: %v", err)
+	}
+	if !reflect.DeepEqual(plan.create, []string{"/dev/sdb", "/dev/sde"}) {
+		t.Errorf("unexpected plan with force: %+v", plan)
+	}
+
+	if _, err := planProvision(devices, pvVGs, "lvmvg", []string{"[/dev/sd"}, filter, false, signatureOf); err == nil {
+		t.Errorf("expected error for invalid glob")
+	}
+	// 没有 glob 和过滤条件时不能使用所有设备
+	if _, err := planProvision(devices, pvVGs, "lvmvg", nil, &deviceFilter{}, true, signatureOf); err == nil {
+		t.Errorf("expected error without device glob and filter")
+	}
+}
+
+func TestPlanProvisionPartitions(t *testing.T) {
+	devices := []*BlockDevice{
+		{Name: "/dev/sdh", Type: "disk", Size: 100 << 30},
+		{Name: "/dev/sdh1", Type: "part", Size: 50 << 30, Parent: "/dev/sdh"},
+		{Name: "/dev/sdh2", Type: "part", Size: 50 << 30, Parent: "/dev/sdh"},
+	}
+	signatures := map[string]string{"/dev/sdh": "gpt"}
+	signatureOf := func(dev string) (string, error) { return signatures[dev], nil }
+	globs := []string{"/dev/sdh*"}
+
+	// 磁盘有分区表时只使用分区
+	plan, err := planProvision(devices, map[string]string{}, "lvmvg", globs, &deviceFilter{}, false, signatureOf)
+	if err != nil {
+		t.Fatalf("plan provision failed: %v", err)
+	}
+	if !reflect.DeepEqual(plan.create, []string{"/dev/sdh1", "/dev/sdh2"}) {
+		t.Errorf("unexpected plan of partitions: %+v", plan)
+	}
+
+	// force 覆盖磁盘的分区表后, 磁盘上的分区不能再作为 pv
+	plan, err = planProvision(devices, map[string]string{}, "lvmvg", globs, &deviceFilter{}, true, signatureOf)
+	if err != nil {
+		t.Fatalf("plan provision failed: %v", err)
+	}
+	if !reflect.DeepEqual(plan.create, []string{"/dev/sdh"}) {
+		t.Errorf("unexpected plan with force: %+v", plan)
+	}
+
+	// 分区先加入计划时跳过所在的磁盘
+	reversed := []*BlockDevice{devices[1], devices[2], devices[0]}
+	plan, err = planProvision(reversed, map[string]string{}, "lvmvg", globs, &deviceFilter{}, true, signatureOf)
+	if err != nil {
+		t.Fatalf("plan provision failed: %v", err)
+	}
+	if !reflect.DeepEqual(plan.create, []string{"/dev/sdh1", "/dev/sdh2"}) {
+		t.Errorf("unexpected plan of reversed devices: %+v", plan)
+	}
+}
@@ -0,0 +1,87 @@
+package lvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// pvs --segments 输出的字段, lv_name 为空的 segment 为空闲空间
+var pvSegmentFields = []string{
+	"pv_name",
+	"vg_name",
+	"pvseg_start",
+	"pvseg_size",
+	"lv_name",
+}
+
+// PVSegment 为 pvs --segments 查询到的 pv 分段信息, Start 和 Size 的单位为 extent
+type PVSegment struct {
+	PVName string
+	VGName string
+	Start  int64
+	Size   int64
+	LVName string
+}
+
+// IsFree segment 是否未分配给任何 lv
+func (seg *PVSegment) IsFree() bool {
+	return len(seg.LVName) == 0
+}
+
+type pvSegmentsReport struct {
+	Report []struct {
+		PV    []map[string]string `json:"pv"`
+		PVSeg []map[string]string `json:"pvseg"`
+	} `json:"report"`
+}
+
+// ListPVSegments 查询 vg 中所有 pv 的分段, vgname 为空时查询所有 vg
+// pvs --segments --reportformat json -o pv_name,vg_name,pvseg_start,pvseg_size,lv_name -S vg_name=lvmvg
+func ListPVSegments(vgname string) ([]*PVSegment, error) {
+	pvsArg := []string{
+		"--segments",
+		"--reportformat", "json",
+		"-o", strings.Join(pvSegmentFields, ","),
+	}
+	if len(vgname) > 0 {
+		pvsArg = append(pvsArg, "-S", "vg_name="+vgname)
+	}
+
+	out, err := runReportCommand(pvs, pvsArg...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePVSegmentsReport(out)
+}
+
+func parsePVSegmentsReport(out []byte) ([]*PVSegment, error) {
+	var report pvSegmentsReport
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("decode pvs report failed: %v", err)
+	}
+
+	var segments []*PVSegment
+	for _, r := range report.Report {
+		// 不同版本的 lvm 中 segment 报告的 key 不同
+		for _, fields := range append(r.PV, r.PVSeg...) {
+			seg := &PVSegment{
+				PVName: fields["pv_name"],
+				VGName: fields["vg_name"],
+				LVName: fields["lv_name"],
+			}
+
+			var err error
+			if seg.Start, err = parseSize(fields["pvseg_start"]); err != nil {
+				return nil, fmt.Errorf("parse pvseg_start of pv %s failed: %v", seg.PVName, err)
+			}
+			if seg.Size, err = parseSize(fields["pvseg_size"]); err != nil {
+				return nil, fmt.Errorf("parse pvseg_size of pv %s failed: %v", seg.PVName, err)
+			}
+			segments = append(segments, seg)
+		}
+	}
+
+	return segments, nil
+}
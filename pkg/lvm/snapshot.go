@@ -0,0 +1,280 @@
+package lvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/config"
+	"github.com/houwenchen/kubernetes-csi/pkg/helper"
+	"k8s.io/klog/v2"
+)
+
+/*
+snapshot 使用 lvcreate --snapshot 实现
+1. origin 位于 thin pool 中时创建 thin snapshot, 不需要指定大小
+root@master:~# lvcreate -s -n snap-xxx lvmvg/pvc-xxx
+2. 普通 lv 创建 COW snapshot, 需要指定存放差异数据的空间大小
+root@master:~# lvcreate -s -n snap-xxx -L 1073741824b lvmvg/pvc-xxx
+*/
+
+const (
+	// 标识由 csi 创建的 snapshot
+	snapshotTag = "csi-snapshot"
+
+	// lvm 不允许 lv 名称以 snapshot 开头
+	reservedSnapshotPrefix = "snapshot"
+	snapshotLVPrefix       = "snap"
+
+	// VolumeSnapshotClass 中指定 COW snapshot 大小占 origin 大小百分比的参数
+	snapshotSizePercentParam = "snapsizepercent"
+)
+
+type Snapshot struct {
+	Name   string
+	VGName string
+	Origin *LVInfo
+	// COW snapshot 的大小, thin snapshot 忽略此字段
+	Size int64
+	// 创建时添加到 snapshot 上的 tag
+	Tags []string
+}
+
+// SnapshotID 生成 snapshot 的 id, 格式为 <vg>/<snapshot>
+// 不包含 origin, thin origin 被删除后 snapshot 仍然可以通过 id 找到
+func SnapshotID(lv *LVInfo) string {
+	return VolumeID(lv.VGName, lv.Name)
+}
+
+// ParseSnapshotID 解析 snapshot id, 返回 vgname 和 snapshot lv 名称
+// 兼容旧版本 <vg>/<origin>/<snapshot> 格式的 id, 忽略其中的 origin
+func ParseSnapshotID(id string) (string, string, error) {
+	parts := strings.Split(id, "/")
+	for _, part := range parts {
+		if len(part) == 0 {
+			return "", "", fmt.Errorf("%w: invalid snapshot id: %s", ErrInvalidArgument, id)
+		}
+	}
+
+	switch len(parts) {
+	case 2:
+		return parts[0], parts[1], nil
+	case 3:
+		return parts[0], parts[2], nil
+	}
+	return "", "", fmt.Errorf("%w: invalid snapshot id: %s", ErrInvalidArgument, id)
+}
+
+// SnapshotLVName 根据 csi snapshot 名称生成 lv 名称
+func SnapshotLVName(name string) string {
+	if strings.HasPrefix(name, reservedSnapshotPrefix) {
+		return snapshotLVPrefix + strings.TrimPrefix(name, reservedSnapshotPrefix)
+	}
+	return name
+}
+
+// 根据 CreateSnapshotRequest 生成 Snapshot
+func NewSnapshotForCreate(config *config.Config, req *csi.CreateSnapshotRequest) (*Snapshot, error) {
+	origin, err := GetOwnedVolumeByID(config.DriverName, req.GetSourceVolumeId())
+	if err != nil {
+		return nil, err
+	}
+	if origin == nil {
+		return nil, fmt.Errorf("%w: source volume %s", ErrLVNotFound, req.GetSourceVolumeId())
+	}
+
+	snap := &Snapshot{
+		Name:   SnapshotLVName(req.GetName()),
+		VGName: origin.VGName,
+		Origin: origin,
+		Tags:   NewSnapshotTags(config.DriverName, req.GetName(), VolumeID(origin.VGName, origin.Name)),
+	}
+
+	if origin.IsThin() {
+		return snap, nil
+	}
+
+	paras := req.GetParameters()
+	percent := config.SnapshotSizePercent
+	if p := helper.GetInsensitiveParameter(&paras, snapshotSizePercentParam); len(p) > 0 {
+		percent, err = strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s: %s", ErrInvalidArgument, snapshotSizePercentParam, p)
+		}
+	}
+	if percent <= 0 {
+		return nil, fmt.Errorf("%w: %s must be positive, got %d", ErrInvalidArgument, snapshotSizePercentParam, percent)
+	}
+
+	// 向上取整, lvm 会再按 extent 对齐
+	snap.Size = (origin.Size*int64(percent) + 99) / 100
+
+	return snap, nil
+}
+
+// CreateSnapshot 创建 snapshot 并返回创建后的 lv 信息
+func CreateSnapshot(snap *Snapshot) (*LVInfo, error) {
+	var createSnapArg []string
+
+	if len(snap.Name) == 0 || snap.Origin == nil {
+		klog.Info("snapshot name and origin can't be empty")
+		return nil, fmt.Errorf("%w: miss snapshot name or origin", ErrInvalidArgument)
+	}
+
+	createSnapArg = append(createSnapArg, "-s", "-n", snap.Name)
+	if !snap.Origin.IsThin() {
+		createSnapArg = append(createSnapArg, "-L", fmt.Sprintf("%db", snap.Size))
+	}
+	for _, tag := range snap.Tags {
+		createSnapArg = append(createSnapArg, "--addtag", tag)
+	}
+	createSnapArg = append(createSnapArg, snap.VGName+"/"+snap.Origin.Name)
+
+	out, err := runCommand(lvCreate, createSnapArg...)
+	if err != nil {
+		klog.Infof("create snapshot failed, name: %s, origin: %s/%s\n", snap.Name, snap.VGName, snap.Origin.Name)
+		return nil, err
+	}
+	klog.Info(string(out))
+
+	lv, err := GetLogicalVolume(snap.VGName, snap.Name)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil {
+		return nil, fmt.Errorf("snapshot %s not found after creation", snap.Name)
+	}
+
+	return lv, nil
+}
+
+// RemoveSnapshot 删除 snapshot
+// lvremove -f lvmvg/snap-xxx
+func RemoveSnapshot(vgname, name string) error {
+	out, err := runCommand(lvRemove, "-f", vgname+"/"+name)
+	if err != nil {
+		klog.Infof("remove snapshot failed, name: %s, vgname: %s\n", name, vgname)
+		return err
+	}
+
+	klog.Info(string(out))
+	return nil
+}
+
+// 普通 lv 的 COW snapshot 会随 origin 一起被 lvremove -f 删除, 有 snapshot 时不能删除或者清除 origin
+// thin snapshot 与 origin 相互独立, 删除 thin origin 不影响 snapshot
+func checkNoSnapshots(lv *LVInfo) error {
+	if lv.IsThin() {
+		return nil
+	}
+
+	lvInfos, err := ListLogicalVolumes(lv.VGName)
+	if err != nil {
+		return err
+	}
+	if names := snapshotsOf(lv, lvInfos); len(names) > 0 {
+		return fmt.Errorf("%w: lv %s has snapshots %s", ErrHasSnapshots, VolumeID(lv.VGName, lv.Name), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// 返回 origin 为 lv 的 snapshot 名称, 包括拷贝数据时创建的临时 snapshot
+func snapshotsOf(lv *LVInfo, lvInfos []*LVInfo) []string {
+	var names []string
+	for _, l := range lvInfos {
+		if l.VGName == lv.VGName && l.Origin == lv.Name {
+			names = append(names, l.Name)
+		}
+	}
+	return names
+}
+
+// IsSnapshot lv 是否为 csi 创建的 snapshot, 只根据 tag 判断, thin origin 被删除后 origin 为空
+func IsSnapshot(lv *LVInfo) bool {
+	return lv.HasTag(snapshotTag)
+}
+
+// SnapshotSource 返回创建 snapshot 时的 source volume id
+// 旧版本创建的 snapshot 没有记录 source, 使用 lvs 中的 origin
+func (lv *LVInfo) SnapshotSource() string {
+	if source, ok := lv.TagValue(snapshotSourceTagPrefix); ok {
+		return source
+	}
+	if len(lv.Origin) > 0 {
+		return VolumeID(lv.VGName, lv.Origin)
+	}
+	return ""
+}
+
+// IsSnapshotOf snapshot 的 source 是否为 volumeID 对应的 volume, 兼容只有 lv 名称的旧 volume id
+func IsSnapshotOf(lv *LVInfo, volumeID string) bool {
+	vgname, name, err := ParseVolumeID(volumeID)
+	if err != nil {
+		return false
+	}
+	sourceVG, sourceName, err := ParseVolumeID(lv.SnapshotSource())
+	if err != nil {
+		return false
+	}
+	return sourceName == name && (len(vgname) == 0 || sourceVG == vgname)
+}
+
+// GetSnapshot 根据 snapshot id 查找由 driver 创建的 snapshot, 不存在时返回 nil
+func GetSnapshot(driverName, id string) (*LVInfo, error) {
+	vgname, name, err := ParseSnapshotID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	lv, err := GetLogicalVolume(vgname, name)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || !IsSnapshot(lv) || !IsOwnedBy(lv, driverName) {
+		return nil, nil
+	}
+
+	return lv, nil
+}
+
+// ListSnapshots 查询所有 vg 中由 driver 创建的 snapshot
+func ListSnapshots(driverName string) ([]*LVInfo, error) {
+	lvInfos, err := ListLogicalVolumes("")
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*LVInfo
+	for _, lv := range lvInfos {
+		if IsSnapshot(lv) && IsOwnedBy(lv, driverName) {
+			snapshots = append(snapshots, lv)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// SnapshotSupported 节点是否能够创建 snapshot: 存在 thin pool, 或者内核加载了 dm-snapshot
+// dmsetup targets 输出示例: snapshot         v1.16.0
+func SnapshotSupported() (bool, error) {
+	pools, err := ListThinPools()
+	if err != nil {
+		return false, err
+	}
+	if len(pools) > 0 {
+		return true, nil
+	}
+
+	out, err := runCommand(dmsetup, "targets")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "snapshot" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
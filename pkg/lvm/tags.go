@@ -0,0 +1,138 @@
+package lvm
+
+import (
+	"strings"
+	"time"
+)
+
+/*
+driver 创建的 lv 都会带上标识归属的 tag, 重启或者多副本时通过 lvs 查询 tag 找回 driver 管理的 lv
+没有 csi-driver tag 的 lv (例如管理员手动创建的) driver 不会做任何操作
+root@master:~# lvs -o lv_name,lv_tags lvmvg
+  LV        LV Tags
+  pvc-xxx   csi-created=2023-06-01T02:00:00Z,csi-driver=csidriver.whou.io,csi-pvc=default/data-0,csi-volume=pvc-xxx
+从数据源创建的 volume 还会带上 csi-source=snapshot:<snapshot id> 或者 csi-source=volume:<volume id>
+snapshot 带上 csi-snapshot 和 csi-snapshot-source=<source volume id>, thin origin 被删除后 lvs 中的 origin 为空, 不能依赖 origin
+*/
+
+const (
+	ownerTagPrefix          = "csi-driver="
+	volumeNameTagPrefix     = "csi-volume="
+	pvcTagPrefix            = "csi-pvc="
+	createdTagPrefix        = "csi-created="
+	snapshotNameTagPrefix   = "csi-snapshot-name="
+	snapshotSourceTagPrefix = "csi-snapshot-source="
+	contentSourceTagPrefix  = "csi-source="
+)
+
+// external-provisioner 开启 --extra-create-metadata 后会在参数中带上 pvc 信息
+const (
+	pvcNameParam      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceParam = "csi.storage.k8s.io/pvc/namespace"
+)
+
+// OwnerTag 生成标识 lv 归属于 driver 的 tag
+func OwnerTag(driverName string) string {
+	return ownerTagPrefix + sanitizeTag(driverName)
+}
+
+// NewVolumeTags 生成创建 volume 时需要添加的 tag
+func NewVolumeTags(driverName, name string, paras map[string]string) []string {
+	tags := []string{
+		OwnerTag(driverName),
+		volumeNameTagPrefix + sanitizeTag(name),
+	}
+
+	pvcName, pvcNamespace := paras[pvcNameParam], paras[pvcNamespaceParam]
+	if len(pvcName) > 0 && len(pvcNamespace) > 0 {
+		tags = append(tags, pvcTagPrefix+sanitizeTag(pvcNamespace+"/"+pvcName))
+	}
+
+	return append(tags, createdTag())
+}
+
+// NewSnapshotTags 生成创建 snapshot 时需要添加的 tag, sourceVolumeID 为 origin 的 volume id
+func NewSnapshotTags(driverName, name, sourceVolumeID string) []string {
+	return []string{
+		OwnerTag(driverName),
+		snapshotTag,
+		snapshotNameTagPrefix + sanitizeTag(name),
+		snapshotSourceTagPrefix + sanitizeTag(sourceVolumeID),
+		createdTag(),
+	}
+}
+
+func createdTag() string {
+	return createdTagPrefix + time.Now().UTC().Format(time.RFC3339)
+}
+
+// lvm tag 只允许 [A-Za-z0-9_+.-/=!:&#], 其他字符替换为 _
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("_+.-/=!:&#", r):
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// 创建时将部分 StorageClass 参数记录到 lv 的 tag 中, 例如 csi-raidtype=raid10
+const paramTagPrefix = "csi-"
+
+// 生成记录参数的 tag
+func paramTag(key, value string) string {
+	return paramTagPrefix + key + "=" + sanitizeTag(value)
+}
+
+// 读取 lv 上记录的参数
+func (lv *LVInfo) paramTagValue(key string) (string, bool) {
+	return lv.TagValue(paramTagPrefix + key + "=")
+}
+
+// TagValue 返回 lv 上以 prefix 开头的 tag 的值
+func (lv *LVInfo) TagValue(prefix string) (string, bool) {
+	for _, t := range lv.Tags {
+		if strings.HasPrefix(t, prefix) {
+			return strings.TrimPrefix(t, prefix), true
+		}
+	}
+	return "", false
+}
+
+// IsOwnedBy lv 是否由 driver 创建
+func IsOwnedBy(lv *LVInfo, driverName string) bool {
+	return lv.HasTag(OwnerTag(driverName))
+}
+
+// ListOwnedLogicalVolumes 查询所有 vg 中由 driver 创建的 volume, 不包括 snapshot
+func ListOwnedLogicalVolumes(driverName string) ([]*LVInfo, error) {
+	lvInfos, err := ListLogicalVolumes("")
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []*LVInfo
+	for _, lv := range lvInfos {
+		if IsOwnedBy(lv, driverName) && !IsSnapshot(lv) {
+			owned = append(owned, lv)
+		}
+	}
+
+	return owned, nil
+}
+
+// GetOwnedLogicalVolume 根据名称查找由 driver 创建的 volume, 不存在时返回 nil
+func GetOwnedLogicalVolume(driverName, vgname, name string) (*LVInfo, error) {
+	lv, err := GetLogicalVolume(vgname, name)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || !IsOwnedBy(lv, driverName) || IsSnapshot(lv) {
+		return nil, nil
+	}
+
+	return lv, nil
+}
@@ -0,0 +1,44 @@
+package lvm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewVolumeTags(t *testing.T) {
+	paras := map[string]string{
+		pvcNameParam:      "data-0",
+		pvcNamespaceParam: "default",
+	}
+
+	tags := NewVolumeTags("csidriver.whou.io", "pvc-1", paras)
+	lv := &LVInfo{Tags: tags}
+
+	if !IsOwnedBy(lv, "csidriver.whou.io") || IsOwnedBy(lv, "other.driver") {
+		t.Errorf("unexpected owner tags: %v", tags)
+	}
+	if v, _ := lv.TagValue(volumeNameTagPrefix); v != "pvc-1" {
+		t.Errorf("unexpected volume name tag: %s", v)
+	}
+	if v, _ := lv.TagValue(pvcTagPrefix); v != "default/data-0" {
+		t.Errorf("unexpected pvc tag: %s", v)
+	}
+	if _, ok := lv.TagValue(createdTagPrefix); !ok {
+		t.Errorf("missing created tag: %v", tags)
+	}
+
+	// 没有 pvc 信息时不添加 pvc tag
+	lv = &LVInfo{Tags: NewVolumeTags("csidriver.whou.io", "pvc-1", nil)}
+	if _, ok := lv.TagValue(pvcTagPrefix); ok {
+		t.Errorf("unexpected pvc tag: %v", lv.Tags)
+	}
+}
+
+func TestSanitizeTag(t *testing.T) {
+	if got := sanitizeTag("a b@c/d=e"); got != "a_b_c/d=e" {
+		t.Errorf("unexpected sanitized tag: %s", got)
+	}
+	if strings.ContainsAny(createdTag(), " ") {
+		t.Errorf("created tag contains invalid chars: %s", createdTag())
+	}
+}
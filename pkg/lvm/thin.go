@@ -0,0 +1,189 @@
+package lvm
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+/*
+thin volume 创建在 thin pool 中, 创建时只分配虚拟容量, 写入数据时才从 pool 中分配空间
+1. 创建 thin pool
+root@master:~# lvcreate -T -l 90%FREE lvmvg/pool
+2. 在 thin pool 中创建 thin volume
+root@master:~# lvcreate -n pvc-xxx -V 10737418240b -T lvmvg/pool
+*/
+
+// StorageClass 中 thin volume 相关的参数
+const (
+	// thin pool 不存在时是否自动创建
+	thinPoolAutoCreateParam = "thinpoolautocreate"
+	// 自动创建的 thin pool 占 vg 剩余空间的百分比
+	thinPoolSizePercentParam = "thinpoolsizepercent"
+	// pool 中 thin volume 虚拟容量之和与 pool 大小的最大比例, 小于等于 0 时不限制
+	OverprovisionRatioParam = "overprovisionratio"
+)
+
+const defaultThinPoolSizePercent = 90
+
+// 保证超配检查和创建 thin lv 之间没有其他 thin lv 被创建
+var thinPoolMutex sync.Mutex
+
+// OverprovisionRatio 返回参数中指定的超配比例, 没有指定时返回 defaultRatio
+func OverprovisionRatio(paras map[string]string, defaultRatio float64) (float64, error) {
+	v, ok := paras[OverprovisionRatioParam]
+	if !ok {
+		return defaultRatio, nil
+	}
+
+	ratio, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidArgument, OverprovisionRatioParam, v)
+	}
+	return ratio, nil
+}
+
+// OverprovisionRatioTags 返回需要添加到 thin lv 上记录超配比例的 tag, 扩容时使用
+func OverprovisionRatioTags(ratio float64) []string {
+	return []string{paramTag(OverprovisionRatioParam, strconv.FormatFloat(ratio, 'g', -1, 64))}
+}
+
+// OverprovisionRatio 从 lv 的 tag 中读取创建时的超配比例, 没有记录时返回 defaultRatio
+func (lv *LVInfo) OverprovisionRatio(defaultRatio float64) (float64, error) {
+	v, ok := lv.paramTagValue(OverprovisionRatioParam)
+	if !ok {
+		return defaultRatio, nil
+	}
+	return OverprovisionRatio(map[string]string{OverprovisionRatioParam: v}, defaultRatio)
+}
+
+// 解析自动创建 thin pool 相关的参数
+func parseThinPoolAutoCreate(paras map[string]string) (bool, int, error) {
+	autoCreate := false
+	if v, ok := paras[thinPoolAutoCreateParam]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidArgument, thinPoolAutoCreateParam, v)
+		}
+		autoCreate = b
+	}
+
+	percent := defaultThinPoolSizePercent
+	if v, ok := paras[thinPoolSizePercentParam]; ok {
+		p, err := strconv.Atoi(v)
+		if err != nil || p <= 0 || p > 100 {
+			return false, 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidArgument, thinPoolSizePercentParam, v)
+		}
+		percent = p
+	}
+
+	return autoCreate, percent, nil
+}
+
+// 在 thin pool 中预留 lv 的虚拟容量后执行 create
+// pool 不存在且允许自动创建时先创建 pool, 超出超配比例时返回 ErrInsufficientSpace
+func withThinPool(lv *LogicalVolume, create func() error) error {
+	thinPoolMutex.Lock()
+	defer thinPoolMutex.Unlock()
+
+	lvInfos, err := ListLogicalVolumes(lv.VGName)
+	if err != nil {
+		return err
+	}
+
+	pool := findThinPool(lvInfos, lv.ThinPool)
+	if pool == nil {
+		if !lv.ThinPoolAutoCreate {
+			return fmt.Errorf("%w: thin pool %s/%s", ErrLVNotFound, lv.VGName, lv.ThinPool)
+		}
+		if err := createThinPool(lv.VGName, lv.ThinPool, lv.ThinPoolSizePercent); err != nil {
+			return err
+		}
+		if pool, err = GetLogicalVolume(lv.VGName, lv.ThinPool); err != nil {
+			return err
+		}
+		if pool == nil || !pool.IsThinPool() {
+			return fmt.Errorf("thin pool %s/%s not found after creation", lv.VGName, lv.ThinPool)
+		}
+	}
+
+	if err := checkOverprovisioning(pool, thinPoolVirtualSize(lvInfos, pool), lv.Size, lv.OverprovisionRatio); err != nil {
+		return err
+	}
+
+	return create()
+}
+
+// ThinPoolTags 返回需要添加到 lv 上记录 thin pool 的 tag, 普通 lv 不需要记录
+func ThinPoolTags(pool string) []string {
+	if len(pool) == 0 {
+		return nil
+	}
+	return []string{paramTag(ThinPoolParam, pool)}
+}
+
+// ThinPool 从 lv 的 tag 中读取创建时指定的 thin pool, 没有记录时使用 lv 所在的 pool
+func (lv *LVInfo) ThinPool() string {
+	if pool, ok := lv.paramTagValue(ThinPoolParam); ok {
+		return pool
+	}
+	if lv.IsThin() {
+		return lv.PoolLV
+	}
+	return ""
+}
+
+// MatchThinPool 已存在的 lv 所在的 thin pool 是否与参数中的一致, 都没有指定时为普通 lv
+func MatchThinPool(paras map[string]string, lv *LVInfo) bool {
+	return sanitizeTag(paras[ThinPoolParam]) == lv.ThinPool()
+}
+
+func findThinPool(lvInfos []*LVInfo, name string) *LVInfo {
+	for _, lv := range lvInfos {
+		if lv.Name == name && lv.IsThinPool() {
+			return lv
+		}
+	}
+	return nil
+}
+
+// lvcreate -T -l 90%FREE lvmvg/pool
+func createThinPool(vgname, pool string, sizePercent int) error {
+	klog.Infof("thin pool %s/%s doesn't exist, create it with %d%% free space of vg", vgname, pool, sizePercent)
+
+	out, err := runCommand(lvCreate, "-T", "-l", fmt.Sprintf("%d%%FREE", sizePercent), vgname+"/"+pool)
+	if err != nil {
+		klog.Infof("create thin pool failed, pool: %s, vgname: %s\n", pool, vgname)
+		return err
+	}
+
+	klog.Info(string(out))
+	return nil
+}
+
+// thin pool 中所有 thin lv (包括 thin snapshot) 的虚拟容量之和
+func thinPoolVirtualSize(lvInfos []*LVInfo, pool *LVInfo) int64 {
+	var used int64
+	for _, lv := range lvInfos {
+		if lv.VGName == pool.VGName && lv.PoolLV == pool.Name {
+			used += lv.Size
+		}
+	}
+	return used
+}
+
+// 已分配的虚拟容量加上 size 不能超过 pool 大小乘以超配比例, ratio 小于等于 0 时不限制
+func checkOverprovisioning(pool *LVInfo, used, size int64, ratio float64) error {
+	if ratio <= 0 {
+		return nil
+	}
+
+	limit := int64(float64(pool.Size) * ratio)
+	if used+size > limit {
+		return fmt.Errorf("%w: thin pool %s/%s has %d bytes virtual capacity allocated, adding %d exceeds limit %d (overprovision ratio %g)",
+			ErrInsufficientSpace, pool.VGName, pool.Name, used, size, limit, ratio)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package lvm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/houwenchen/kubernetes-csi/pkg/config"
+)
+
+func TestCheckOverprovisioning(t *testing.T) {
+	pool := &LVInfo{Name: "pool", VGName: "lvmvg", Attr: "twi-aotz--", Size: 1000}
+	lvInfos := []*LVInfo{
+		pool,
+		{Name: "pvc-1", VGName: "lvmvg", PoolLV: "pool", Size: 800},
+		{Name: "pvc-2", VGName: "lvmvg", PoolLV: "pool", Size: 700},
+		{Name: "pvc-3", VGName: "lvmvg", PoolLV: "other", Size: 700},
+		{Name: "pvc-4", VGName: "lvmvg", Size: 700},
+	}
+
+	used := thinPoolVirtualSize(lvInfos, pool)
+	if used != 1500 {
+		t.Fatalf("unexpected virtual size: %d", used)
+	}
+
+	if err := checkOverprovisioning(pool, used, 500, 2); err != nil {
+		t.Errorf("expected size within ratio to be allowed, got %v", err)
+	}
+	if err := checkOverprovisioning(pool, used, 501, 2); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("expected ErrInsufficientSpace, got %v", err)
+	}
+	if err := checkOverprovisioning(pool, used, 1<<40, 0); err != nil {
+		t.Errorf("expected no limit when ratio is 0, got %v", err)
+	}
+}
+
+func TestNewThinLogicalVolumeForCreate(t *testing.T) {
+	cfg := &config.Config{DriverName: "csidriver.whou.io", VolumeDir: "/dev", ThinPoolOverprovisionRatio: 1}
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-1",
+		Parameters: map[string]string{
+			VGNameParam:             "lvmvg",
+			ThinPoolParam:           "pool",
+			thinPoolAutoCreateParam: "true",
+			OverprovisionRatioParam: "5",
+		},
+	}
+
+	lv, err := NewLogicalVolumeForCreate(cfg, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv.ThinPool != "pool" || !lv.ThinPoolAutoCreate || lv.ThinPoolSizePercent != defaultThinPoolSizePercent || lv.OverprovisionRatio != 5 {
+		t.Errorf("unexpected thin lv: %+v", lv)
+	}
+
+	for _, paras := range []map[string]string{
+		{VGNameParam: "lvmvg", ThinPoolParam: "pool", OverprovisionRatioParam: "abc"},
+		{VGNameParam: "lvmvg", ThinPoolParam: "pool", thinPoolAutoCreateParam: "maybe"},
+		{VGNameParam: "lvmvg", ThinPoolParam: "pool", thinPoolSizePercentParam: "101"},
+	} {
+		req.Parameters = paras
+		if _, err := NewLogicalVolumeForCreate(cfg, req); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("expected ErrInvalidArgument for %v, got %v", paras, err)
+		}
+	}
+}
+
+func TestMatchThinPool(t *testing.T) {
+	thin := &LVInfo{Name: "pvc-1", VGName: "lvmvg", Attr: "Vwi-a-tz--", PoolLV: "pool", Tags: ThinPoolTags("pool")}
+	if !MatchThinPool(map[string]string{ThinPoolParam: "pool"}, thin) {
+		t.Errorf("expected thin lv to match pool")
+	}
+	if MatchThinPool(map[string]string{ThinPoolParam: "other"}, thin) || MatchThinPool(map[string]string{}, thin) {
+		t.Errorf("expected thin lv not to match other pool or thick request")
+	}
+
+	// 没有记录 tag 的旧 lv 使用所在的 pool
+	old := &LVInfo{Name: "pvc-2", VGName: "lvmvg", Attr: "Vwi-a-tz--", PoolLV: "pool"}
+	if !MatchThinPool(map[string]string{ThinPoolParam: "pool"}, old) {
+		t.Errorf("expected old thin lv to match its pool")
+	}
+
+	thick := &LVInfo{Name: "pvc-3", VGName: "lvmvg", Attr: "-wi-a-----", Tags: ThinPoolTags("")}
+	if !MatchThinPool(map[string]string{}, thick) || MatchThinPool(map[string]string{ThinPoolParam: "pool"}, thick) {
+		t.Errorf("unexpected match for thick lv")
+	}
+}
+
+func TestOverprovisionRatioOfLV(t *testing.T) {
+	lv := &LVInfo{Name: "pvc-1", VGName: "lvmvg", PoolLV: "pool", Tags: OverprovisionRatioTags(2.5)}
+	if ratio, err := lv.OverprovisionRatio(0); err != nil || ratio != 2.5 {
+		t.Errorf("expected ratio 2.5 from tags %v, got %g, %v", lv.Tags, ratio, err)
+	}
+
+	// 没有记录时使用默认比例
+	lv = &LVInfo{Name: "pvc-2", VGName: "lvmvg", PoolLV: "pool"}
+	if ratio, err := lv.OverprovisionRatio(3); err != nil || ratio != 3 {
+		t.Errorf("expected default ratio 3, got %g, %v", ratio, err)
+	}
+}
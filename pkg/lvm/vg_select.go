@@ -0,0 +1,134 @@
+package lvm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// StorageClass 中选择 vg 的参数, 指定 vgname 时忽略
+const (
+	// 匹配 vg 名称的正则表达式, 需要完整匹配
+	VGPatternParam = "vgpattern"
+	// 多个 vg 满足条件时的选择策略
+	VGPolicyParam = "vgpolicy"
+)
+
+// vg 选择策略
+const (
+	// 选择剩余空间最少且能容纳 volume 的 vg, 尽量用满一个 vg
+	VGPolicyBinpack = "binpack"
+	// 选择 lv 数量最少的 vg, 使 volume 分散到各个 vg
+	VGPolicySpread = "spread"
+	// 选择剩余空间最多的 vg
+	VGPolicyMostFree = "most-free"
+
+	defaultVGPolicy = VGPolicyMostFree
+)
+
+// SelectVolumeGroup 根据 vgpattern 和 vgpolicy 选择能够按 layout 容纳 size 大小 volume 的 vg
+func SelectVolumeGroup(paras map[string]string, size int64, layout *Layout) (string, error) {
+	pattern, err := compileVGPattern(paras[VGPatternParam])
+	if err != nil {
+		return "", err
+	}
+
+	policy := paras[VGPolicyParam]
+	if len(policy) == 0 {
+		policy = defaultVGPolicy
+	}
+
+	vgInfos, err := ListVolumeGroups()
+	if err != nil {
+		return "", err
+	}
+
+	vg, err := selectVolumeGroup(vgInfos, pattern, policy, size, layout)
+	if err != nil {
+		return "", err
+	}
+	return vg.Name, nil
+}
+
+// MatchVolumeGroup vg 是否满足参数中的 vgname 或者 vgpattern
+func MatchVolumeGroup(paras map[string]string, vgname string) (bool, error) {
+	if v, ok := paras[VGNameParam]; ok {
+		return v == vgname, nil
+	}
+	v, ok := paras[VGPatternParam]
+	if !ok {
+		return true, nil
+	}
+
+	pattern, err := compileVGPattern(v)
+	if err != nil {
+		return false, err
+	}
+	return pattern.MatchString(vgname), nil
+}
+
+// vgpattern 需要匹配完整的 vg 名称
+func compileVGPattern(s string) (*regexp.Regexp, error) {
+	pattern, err := regexp.Compile("^(?:" + s + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s %q: %v", ErrInvalidArgument, VGPatternParam, s, err)
+	}
+	return pattern, nil
+}
+
+func selectVolumeGroup(vgInfos []*VGInfo, pattern *regexp.Regexp, policy string, size int64, layout *Layout) (*VGInfo, error) {
+	var less func(a, b *VGInfo) bool
+	switch policy {
+	case VGPolicyBinpack:
+		less = func(a, b *VGInfo) bool { return a.Free < b.Free }
+	case VGPolicySpread:
+		less = func(a, b *VGInfo) bool {
+			if a.LVCount != b.LVCount {
+				return a.LVCount < b.LVCount
+			}
+			return a.Free > b.Free
+		}
+	case VGPolicyMostFree:
+		less = func(a, b *VGInfo) bool { return a.Free > b.Free }
+	default:
+		return nil, fmt.Errorf("%w: unknown %s %q", ErrInvalidArgument, VGPolicyParam, policy)
+	}
+
+	minPVs := layout.RequiredPVs()
+	matched := 0
+	var candidates []*VGInfo
+	for _, vg := range vgInfos {
+		if !pattern.MatchString(vg.Name) {
+			continue
+		}
+		matched++
+		// 条带和镜像需要分布在不同的 pv 上
+		if vg.PVCount < int64(minPVs) {
+			continue
+		}
+		// 镜像和校验数据需要额外的空间, 与 GetCapacity 的折算方式一致
+		if vg.Free >= layout.RequiredSpace(RoundUpToExtent(size, vg.ExtentSize)) {
+			candidates = append(candidates, vg)
+		}
+	}
+
+	if matched == 0 {
+		return nil, fmt.Errorf("%w: no vg matches %s", ErrVGNotFound, pattern)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: none of %d vgs matching %s has enough free space for %d bytes with layout %v and at least %d pvs", ErrInsufficientSpace, matched, pattern, size, layout.Args(), minPVs)
+	}
+
+	// 策略相同时按名称排序, 保证结果稳定
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if less(candidates[i], candidates[j]) {
+			return true
+		}
+		if less(candidates[j], candidates[i]) {
+			return false
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	return candidates[0], nil
+}
@@ -0,0 +1,105 @@
+package lvm
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestSelectVolumeGroup(t *testing.T) {
+	extentSize := int64(4 * 1024 * 1024)
+	vgInfos := []*VGInfo{
+		{Name: "data-a", Free: 100 * extentSize, ExtentSize: extentSize, LVCount: 3, PVCount: 1},
+		{Name: "data-b", Free: 50 * extentSize, ExtentSize: extentSize, LVCount: 1, PVCount: 2},
+		{Name: "data-c", Free: 10 * extentSize, ExtentSize: extentSize, LVCount: 0, PVCount: 1},
+		{Name: "system", Free: 1000 * extentSize, ExtentSize: extentSize, LVCount: 0, PVCount: 4},
+	}
+	pattern := regexp.MustCompile("^(?:data-.*)$")
+	linear := &Layout{Stripes: 1}
+	mirror := &Layout{Stripes: 1, Mirrors: 1}
+
+	cases := []struct {
+		policy string
+		size   int64
+		layout *Layout
+		want   string
+	}{
+		{policy: VGPolicyMostFree, size: extentSize, layout: linear, want: "data-a"},
+		{policy: VGPolicyBinpack, size: extentSize, layout: linear, want: "data-c"},
+		{policy: VGPolicyBinpack, size: 20 * extentSize, layout: linear, want: "data-b"},
+		{policy: VGPolicySpread, size: extentSize, layout: linear, want: "data-c"},
+		{policy: VGPolicySpread, size: 20 * extentSize, layout: linear, want: "data-b"},
+		// 条带和镜像需要多个 pv
+		{policy: VGPolicyMostFree, size: extentSize, layout: mirror, want: "data-b"},
+	}
+	for _, c := range cases {
+		vg, err := selectVolumeGroup(vgInfos, pattern, c.policy, c.size, c.layout)
+		if err != nil {
+			t.Errorf("policy %s, size %d: %v", c.policy, c.size, err)
+			continue
+		}
+		if vg.Name != c.want {
+			t.Errorf("policy %s, size %d: expected %s, got %s", c.policy, c.size, c.want, vg.Name)
+		}
+	}
+
+	if _, err := selectVolumeGroup(vgInfos, pattern, VGPolicyMostFree, 200*extentSize, linear); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("expected ErrInsufficientSpace, got %v", err)
+	}
+	if _, err := selectVolumeGroup(vgInfos, pattern, VGPolicyMostFree, extentSize, &Layout{Stripes: 3}); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("expected ErrInsufficientSpace without enough pvs, got %v", err)
+	}
+	// 镜像需要两倍的空间, data-b 的 50 个 extent 放不下 30 个 extent 的镜像
+	if _, err := selectVolumeGroup(vgInfos, pattern, VGPolicyMostFree, 30*extentSize, mirror); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("expected ErrInsufficientSpace for mirror, got %v", err)
+	}
+	if _, err := selectVolumeGroup(vgInfos, regexp.MustCompile("^(?:none)$"), VGPolicyMostFree, extentSize, linear); !errors.Is(err, ErrVGNotFound) {
+		t.Errorf("expected ErrVGNotFound, got %v", err)
+	}
+	if _, err := selectVolumeGroup(vgInfos, pattern, "random", extentSize, linear); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected ErrInvalidArgument, got %v", err)
+	}
+}
+
+func TestMatchVolumeGroup(t *testing.T) {
+	cases := []struct {
+		paras map[string]string
+		vg    string
+		want  bool
+	}{
+		{paras: map[string]string{VGNameParam: "lvmvg"}, vg: "lvmvg", want: true},
+		{paras: map[string]string{VGNameParam: "lvmvg"}, vg: "other", want: false},
+		{paras: map[string]string{VGPatternParam: "data-.*"}, vg: "data-a", want: true},
+		// 需要完整匹配 vg 名称
+		{paras: map[string]string{VGPatternParam: "data"}, vg: "data-a", want: false},
+	}
+	for _, c := range cases {
+		if got, err := MatchVolumeGroup(c.paras, c.vg); err != nil || got != c.want {
+			t.Errorf("MatchVolumeGroup(%v, %s) = %v, %v", c.paras, c.vg, got, err)
+		}
+	}
+}
+
+func TestParseVolumeID(t *testing.T) {
+	vg, name, err := ParseVolumeID("lvmvg/pvc-1")
+	if err != nil || vg != "lvmvg" || name != "pvc-1" {
+		t.Errorf("unexpected result: %s %s %v", vg, name, err)
+	}
+
+	// 兼容只有 lv 名称的旧 volume id
+	vg, name, err = ParseVolumeID("pvc-1")
+	if err != nil || vg != "" || name != "pvc-1" {
+		t.Errorf("unexpected result of legacy id: %s %s %v", vg, name, err)
+	}
+
+	for _, id := range []string{"", "lvmvg/", "/pvc-1", "a/b/c"} {
+		if _, _, err := ParseVolumeID(id); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("expected ErrInvalidArgument for volume id %q, got %v", id, err)
+		}
+	}
+
+	snap := &LVInfo{Name: "snap-1", VGName: "lvmvg", Origin: "pvc-1"}
+	if !IsSnapshotOf(snap, "lvmvg/pvc-1") || !IsSnapshotOf(snap, "pvc-1") || IsSnapshotOf(snap, "other/pvc-1") {
+		t.Errorf("unexpected IsSnapshotOf result")
+	}
+}
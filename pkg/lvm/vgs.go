@@ -0,0 +1,132 @@
+package lvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// vgs 输出的字段
+var vgsFields = []string{
+	"vg_name",
+	"vg_uuid",
+	"vg_size",
+	"vg_free",
+	"vg_extent_size",
+	"vg_extent_count",
+	"vg_free_count",
+	"pv_count",
+	"lv_count",
+	"vg_tags",
+}
+
+// VGInfo 为 vgs 查询到的 vg 信息
+type VGInfo struct {
+	Name        string
+	UUID        string
+	Size        int64
+	Free        int64
+	ExtentSize  int64
+	ExtentCount int64
+	FreeCount   int64
+	PVCount     int64
+	LVCount     int64
+	Tags        []string
+}
+
+type vgsReport struct {
+	Report []struct {
+		VG []map[string]string `json:"vg"`
+	} `json:"report"`
+}
+
+// ListVolumeGroups 查询所有 vg
+// vgs --reportformat json --units b --nosuffix -o vg_name,vg_size,...
+func ListVolumeGroups() ([]*VGInfo, error) {
+	vgsArg := []string{
+		"--reportformat", "json",
+		"--units", "b",
+		"--nosuffix",
+		"-o", strings.Join(vgsFields, ","),
+	}
+
+	out, err := runReportCommand(vgs, vgsArg...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseVGsReport(out)
+}
+
+func parseVGsReport(out []byte) ([]*VGInfo, error) {
+	var report vgsReport
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("decode vgs report failed: %v", err)
+	}
+
+	var vgInfos []*VGInfo
+	for _, r := range report.Report {
+		for _, fields := range r.VG {
+			vg, err := parseVGInfo(fields)
+			if err != nil {
+				return nil, err
+			}
+			vgInfos = append(vgInfos, vg)
+		}
+	}
+
+	return vgInfos, nil
+}
+
+func parseVGInfo(fields map[string]string) (*VGInfo, error) {
+	vg := &VGInfo{
+		Name: fields["vg_name"],
+		UUID: fields["vg_uuid"],
+	}
+
+	for field, value := range map[string]*int64{
+		"vg_size":         &vg.Size,
+		"vg_free":         &vg.Free,
+		"vg_extent_size":  &vg.ExtentSize,
+		"vg_extent_count": &vg.ExtentCount,
+		"vg_free_count":   &vg.FreeCount,
+		"pv_count":        &vg.PVCount,
+		"lv_count":        &vg.LVCount,
+	} {
+		v, err := parseSize(fields[field])
+		if err != nil {
+			return nil, fmt.Errorf("parse %s of vg %s failed: %v", field, vg.Name, err)
+		}
+		*value = v
+	}
+
+	if tags := fields["vg_tags"]; len(tags) > 0 {
+		vg.Tags = strings.Split(tags, ",")
+	}
+
+	return vg, nil
+}
+
+// GetVolumeGroup 根据名称查找 vg, 不存在时返回 nil
+func GetVolumeGroup(name string) (*VGInfo, error) {
+	vgInfos, err := ListVolumeGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vg := range vgInfos {
+		if vg.Name == name {
+			return vg, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RoundUpToExtent 将 size 按 vg 的 extent 大小向上取整
+func RoundUpToExtent(size, extentSize int64) int64 {
+	if extentSize <= 0 {
+		return size
+	}
+	return (size + extentSize - 1) / extentSize * extentSize
+}
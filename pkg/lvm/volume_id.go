@@ -0,0 +1,38 @@
+package lvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VolumeID 生成 volume id, 格式为 <vg>/<lv>, 后续操作根据 id 直接找到 lv 所在的 vg
+func VolumeID(vgname, name string) string {
+	return vgname + "/" + name
+}
+
+// ParseVolumeID 解析 volume id, 返回 vgname 和 lv 名称
+// 兼容旧版本只使用 lv 名称作为 id 的 volume, 此时返回的 vgname 为空, 需要在所有 vg 中查找
+func ParseVolumeID(id string) (string, string, error) {
+	if !strings.Contains(id, "/") {
+		if len(id) == 0 {
+			return "", "", fmt.Errorf("%w: empty volume id", ErrInvalidArgument)
+		}
+		return "", id, nil
+	}
+
+	parts := strings.Split(id, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", fmt.Errorf("%w: invalid volume id: %s", ErrInvalidArgument, id)
+	}
+	return parts[0], parts[1], nil
+}
+
+// GetOwnedVolumeByID 根据 volume id 查找由 driver 创建的 volume, 不存在时返回 nil
+func GetOwnedVolumeByID(driverName, id string) (*LVInfo, error) {
+	vgname, name, err := ParseVolumeID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetOwnedLogicalVolume(driverName, vgname, name)
+}
@@ -0,0 +1,298 @@
+package lvm
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+/*
+删除 lv 前按照 wipePolicy 清除数据, 避免后续分配到相同 extent 的 lv 读到之前的数据
+1. discard: 丢弃设备上的数据, 设备不支持 discard 时写零
+root@master:~# blkdiscard -o 0 -l 1073741824 /dev/lvmvg/pvc-xxx
+2. zero: 写零, 优先使用设备的 write zeroes
+root@master:~# blkdiscard -z -o 0 -l 1073741824 /dev/lvmvg/pvc-xxx
+3. shred: 先写一遍随机数据再写零
+root@master:~# shred -v -n 1 -z /dev/lvmvg/pvc-xxx
+thin lv 同样按照 wipePolicy 清除, discard 失败时写零, 不能假设 thin pool 分配新块时会清零
+挂了缓存的 lv 先拆下缓存刷回脏数据, 按相同方式清除缓存 lv 并删除后再清除 lv
+root@master:~# lvconvert -y --splitcache lvmvg/pvc-xxx
+清除在后台进行, 完成后给 lv 打上 csi-wiped tag, 清除过程中 DeleteVolume 返回 Aborted, 由 CO 重试
+*/
+
+// StorageClass 中指定删除前清除数据方式的参数
+const WipePolicyParam = "wipePolicy"
+
+// 清除数据的方式
+const (
+	WipePolicyNone    = "none"
+	WipePolicyDiscard = "discard"
+	WipePolicyZero    = "zero"
+	WipePolicyShred   = "shred"
+)
+
+// 清除数据所需命令
+const (
+	blkdiscard string = "blkdiscard"
+	shred      string = "shred"
+)
+
+// 清除完成后添加的 tag, driver 重启后不需要重新清除
+const wipedTag = "csi-wiped"
+
+const (
+	// discard 和写零时分段执行, 每段完成后打印进度
+	wipeProgressSteps = 10
+	// 分段的大小按 1MiB 对齐, 保证满足设备的扇区对齐要求
+	wipeAlignment int64 = 1024 * 1024
+	// 回退到写零时每次写入的大小
+	zeroBufferSize = 4 * 1024 * 1024
+)
+
+// 正在后台清除的 lv, key 为 volume id
+var (
+	wipesMutex sync.Mutex
+	wipes      = make(map[string]*wipeState)
+)
+
+type wipeState struct {
+	done bool
+	err  error
+}
+
+// ParseWipePolicy 解析参数中的 wipePolicy, 没有指定时为 none
+func ParseWipePolicy(paras map[string]string) (string, error) {
+	policy, ok := paras[WipePolicyParam]
+	if !ok {
+		return WipePolicyNone, nil
+	}
+
+	switch policy {
+	case WipePolicyNone, WipePolicyDiscard, WipePolicyZero, WipePolicyShred:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: unsupported %s %q", ErrInvalidArgument, WipePolicyParam, policy)
+}
+
+// WipePolicyTags 返回需要添加到 lv 上记录 wipePolicy 的 tag, none 时不需要记录
+func WipePolicyTags(policy string) []string {
+	if policy == WipePolicyNone {
+		return nil
+	}
+	return []string{paramTag(WipePolicyParam, policy)}
+}
+
+// WipePolicy 从 lv 的 tag 中读取创建时记录的 wipePolicy, 没有记录时为 none
+func (lv *LVInfo) WipePolicy() string {
+	if policy, ok := lv.paramTagValue(WipePolicyParam); ok {
+		return policy
+	}
+	return WipePolicyNone
+}
+
+// 删除 lv 前检查 lv 是否仍在使用并清除数据
+func prepareRemove(lv *LVInfo, wait bool) error {
+	// 后台清除时设备被 driver 自己打开, 重试时直接返回清除进度, 不能当作 lv 仍在使用
+	// lv 仍被挂载或者打开时不能删除, 需要在开始清除数据之前检查
+	if !isWiping(VolumeID(lv.VGName, lv.Name)) {
+		if err := checkNotInUse(lv); err != nil {
+			return err
+		}
+	}
+
+	return wipeBeforeRemove(lv, wait)
+}
+
+// lv 是否正在后台清除
+func isWiping(id string) bool {
+	wipesMutex.Lock()
+	defer wipesMutex.Unlock()
+
+	state, ok := wipes[id]
+	return ok && !state.done
+}
+
+// 删除 lv 前按照 wipePolicy 清除数据, wait 为 false 时在后台清除, 清除完成前返回 ErrBusy
+func wipeBeforeRemove(lv *LVInfo, wait bool) error {
+	policy := lv.WipePolicy()
+	if policy == WipePolicyNone || lv.HasTag(wipedTag) {
+		return nil
+	}
+
+	if wait {
+		return wipeLogicalVolume(lv, policy)
+	}
+
+	id := VolumeID(lv.VGName, lv.Name)
+
+	wipesMutex.Lock()
+	defer wipesMutex.Unlock()
+
+	state, ok := wipes[id]
+	if !ok {
+		state = &wipeState{}
+		wipes[id] = state
+		go func() {
+			err := wipeLogicalVolume(lv, policy)
+
+			wipesMutex.Lock()
+			defer wipesMutex.Unlock()
+			state.done, state.err = true, err
+		}()
+		return fmt.Errorf("%w: wiping lv %s with policy %s", ErrBusy, id, policy)
+	}
+
+	if !state.done {
+		return fmt.Errorf("%w: lv %s is still being wiped with policy %s", ErrBusy, id, policy)
+	}
+
+	// 清除失败时返回错误, 下次重试时重新清除
+	delete(wipes, id)
+	return state.err
+}
+
+// 清除 lv 上的数据并打上 csi-wiped tag
+func wipeLogicalVolume(lv *LVInfo, policy string) error {
+	id := VolumeID(lv.VGName, lv.Name)
+
+	// 缓存 lv 中也保存了数据, 上次清除中断时缓存可能已经拆下
+	if lv.Cache() != nil {
+		if err := wipeCache(lv, policy); err != nil {
+			return err
+		}
+	}
+
+	if err := wipeDevice(lv, policy); err != nil {
+		return err
+	}
+
+	if _, err := runCommand(lvChange, "--addtag", wipedTag, lv.VGName+"/"+lv.Name); err != nil {
+		return err
+	}
+
+	klog.Infof("lv %s is wiped with policy %s", id, policy)
+	return nil
+}
+
+// 拆下缓存并刷回脏数据, 清除缓存 lv 后删除
+func wipeCache(lv *LVInfo, policy string) error {
+	if lv.IsCached() {
+		if err := detachCache(lv.VGName, lv.Name, true); err != nil {
+			return err
+		}
+	}
+
+	cache, err := GetLogicalVolume(lv.VGName, cacheVolumeName(lv.Name))
+	if err != nil || cache == nil {
+		return err
+	}
+	if err := wipeDevice(cache, policy); err != nil {
+		return err
+	}
+	return removeDetachedCache(lv.VGName, lv.Name)
+}
+
+// 按照 wipePolicy 清除 lv 设备上的数据
+func wipeDevice(lv *LVInfo, policy string) error {
+	id := VolumeID(lv.VGName, lv.Name)
+
+	// 未激活的 lv 没有设备文件
+	if !lv.IsActive() {
+		if err := ActivateLogicalVolume(lv.VGName, lv.Name); err != nil {
+			return err
+		}
+	}
+
+	klog.Infof("start wiping lv %s with policy %s, size: %d", id, policy, lv.Size)
+
+	var err error
+	switch policy {
+	case WipePolicyDiscard:
+		if err = discardDevice(lv.Path, lv.Size, false); err != nil {
+			klog.Warningf("discard lv %s failed, fall back to zeroing: %v", id, err)
+			err = zeroDevice(lv.Path, lv.Size)
+		}
+	case WipePolicyZero:
+		if err = discardDevice(lv.Path, lv.Size, true); err != nil {
+			klog.Warningf("write zeroes to lv %s failed, fall back to zeroing by writing: %v", id, err)
+			err = zeroDevice(lv.Path, lv.Size)
+		}
+	case WipePolicyShred:
+		var out []byte
+		out, err = runCommand(shred, "-v", "-n", "1", "-z", lv.Path)
+		klog.Info(string(out))
+	default:
+		err = fmt.Errorf("%w: unsupported %s %q", ErrInvalidArgument, WipePolicyParam, policy)
+	}
+	if err != nil {
+		klog.Errorf("wipe lv %s failed: %v", id, err)
+	}
+	return err
+}
+
+// 分段执行 blkdiscard, zero 为 true 时使用 write zeroes
+// blkdiscard [-z] -o offset -l length /dev/lvmvg/pvc-xxx
+func discardDevice(path string, size int64, zero bool) error {
+	for _, r := range wipeRanges(size) {
+		args := []string{"-o", strconv.FormatInt(r[0], 10), "-l", strconv.FormatInt(r[1], 10), path}
+		if zero {
+			args = append([]string{"-z"}, args...)
+		}
+		if _, err := runCommand(blkdiscard, args...); err != nil {
+			return err
+		}
+		klog.Infof("wiping %s: %d%%", path, (r[0]+r[1])*100/size)
+	}
+	return nil
+}
+
+// 将 size 按进度分成若干段, 返回每段的 offset 和 length
+func wipeRanges(size int64) [][2]int64 {
+	step := size / wipeProgressSteps
+	step = (step + wipeAlignment - 1) / wipeAlignment * wipeAlignment
+	if step == 0 {
+		step = wipeAlignment
+	}
+
+	var ranges [][2]int64
+	for offset := int64(0); offset < size; offset += step {
+		length := step
+		if offset+length > size {
+			length = size - offset
+		}
+		ranges = append(ranges, [2]int64{offset, length})
+	}
+	return ranges
+}
+
+// 设备不支持 discard 和 write zeroes 时直接写零
+func zeroDevice(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, zeroBufferSize)
+	for _, r := range wipeRanges(size) {
+		for written := int64(0); written < r[1]; {
+			n := int64(len(buf))
+			if n > r[1]-written {
+				n = r[1] - written
+			}
+			if _, err := f.WriteAt(buf[:n], r[0]+written); err != nil {
+				return fmt.Errorf("write zeroes to %s failed: %v", path, err)
+			}
+			written += n
+		}
+		klog.Infof("zeroing %s: %d%%", path, (r[0]+r[1])*100/size)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync %s failed: %v", path, err)
+	}
+	return nil
+}
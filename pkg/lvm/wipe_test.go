@@ -0,0 +1,142 @@
+package lvm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseWipePolicy(t *testing.T) {
+	if policy, err := ParseWipePolicy(map[string]string{}); err != nil || policy != WipePolicyNone {
+		t.Errorf("expected default policy none, got %q, %v", policy, err)
+	}
+	if _, err := ParseWipePolicy(map[string]string{WipePolicyParam: "random"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected ErrInvalidArgument, got %v", err)
+	}
+
+	policy, err := ParseWipePolicy(map[string]string{WipePolicyParam: WipePolicyZero})
+	if err != nil {
+		t.Fatalf("parse wipe policy failed: %v", err)
+	}
+	lv := &LVInfo{Name: "pvc-1", VGName: "lvmvg", Tags: WipePolicyTags(policy)}
+	if lv.WipePolicy() != WipePolicyZero {
+		t.Errorf("expected policy %s from tags %v, got %s", WipePolicyZero, lv.Tags, lv.WipePolicy())
+	}
+	if tags := WipePolicyTags(WipePolicyNone); len(tags) != 0 {
+		t.Errorf("expected no tags for policy none, got %v", tags)
+	}
+}
+
+func TestWipeRanges(t *testing.T) {
+	size := int64(100*1024*1024 + 512)
+	ranges := wipeRanges(size)
+
+	var next int64
+	for _, r := range ranges {
+		if r[0] != next || r[0]%wipeAlignment != 0 {
+			t.Fatalf("unexpected range %v in %v", r, ranges)
+		}
+		next = r[0] + r[1]
+	}
+	if next != size || len(ranges) > wipeProgressSteps+1 {
+		t.Errorf("ranges %v don't cover size %d", ranges, size)
+	}
+
+	if ranges = wipeRanges(4096); len(ranges) != 1 || ranges[0] != [2]int64{0, 4096} {
+		t.Errorf("unexpected ranges for small device: %v", ranges)
+	}
+}
+
+func TestZeroDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device")
+	data := bytes.Repeat([]byte{0xff}, 3*1024*1024+100)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write test device failed: %v", err)
+	}
+
+	if err := zeroDevice(path, int64(len(data))); err != nil {
+		t.Fatalf("zero device failed: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read test device failed: %v", err)
+	}
+	if len(got) != len(data) || !bytes.Equal(got, make([]byte, len(data))) {
+		t.Errorf("device is not zeroed")
+	}
+}
+
+func TestWipeBeforeRemove(t *testing.T) {
+	// 没有 wipePolicy 或者已经清除过时直接删除
+	if err := wipeBeforeRemove(&LVInfo{Name: "pvc-1", VGName: "lvmvg"}, false); err != nil {
+		t.Errorf("expected no wipe without policy, got %v", err)
+	}
+	wiped := &LVInfo{Name: "pvc-2", VGName: "lvmvg", Tags: append(WipePolicyTags(WipePolicyZero), wipedTag)}
+	if err := wipeBeforeRemove(wiped, false); err != nil {
+		t.Errorf("expected no wipe for wiped lv, got %v", err)
+	}
+
+	// 清除过程中返回 ErrBusy, 失败后返回错误并在下次重试时重新清除
+	lv := &LVInfo{Name: "pvc-3", VGName: "lvmvg", Tags: WipePolicyTags(WipePolicyZero)}
+	id := VolumeID(lv.VGName, lv.Name)
+	state := &wipeState{}
+	wipes[id] = state
+	defer delete(wipes, id)
+
+	if err := wipeBeforeRemove(lv, false); !errors.Is(err, ErrBusy) {
+		t.Errorf("expected ErrBusy while wiping, got %v", err)
+	}
+
+	wipeErr := errors.New("device is gone")
+	state.done, state.err = true, wipeErr
+	if err := wipeBeforeRemove(lv, false); !errors.Is(err, wipeErr) {
+		t.Errorf("expected wipe error, got %v", err)
+	}
+	if _, ok := wipes[id]; ok {
+		t.Errorf("finished wipe of %s should be forgotten", id)
+	}
+}
+
+func TestPrepareRemoveWhileWiping(t *testing.T) {
+	// 后台清除时设备被 driver 打开, 重试时返回 ErrBusy 而不是 ErrInUse
+	lv := &LVInfo{Name: "pvc-4", VGName: "lvmvg", Attr: "-wi-ao----", Path: "/dev/lvmvg/pvc-4", Tags: WipePolicyTags(WipePolicyZero)}
+	id := VolumeID(lv.VGName, lv.Name)
+	state := &wipeState{}
+	wipes[id] = state
+	defer delete(wipes, id)
+
+	if err := prepareRemove(lv, false); !errors.Is(err, ErrBusy) {
+		t.Errorf("expected ErrBusy while wiping, got %v", err)
+	}
+
+	// 清除完成后仍然打开的 lv 不能删除
+	state.done = true
+	if err := prepareRemove(lv, false); !errors.Is(err, ErrInUse) {
+		t.Errorf("expected ErrInUse after wiping, got %v", err)
+	}
+}
+
+func TestWipeThinDeviceFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device")
+	data := bytes.Repeat([]byte{0xff}, 1024*1024)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write test device failed: %v", err)
+	}
+
+	// 普通文件不支持 discard, thin lv 也需要回退到写零, 不能跳过清除
+	lv := &LVInfo{Name: "pvc-5", VGName: "lvmvg", Attr: "Vwi-a-tz--", PoolLV: "pool", Path: path, Size: int64(len(data))}
+	if err := wipeDevice(lv, WipePolicyDiscard); err != nil {
+		t.Fatalf("wipe thin device failed: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read test device failed: %v", err)
+	}
+	if !bytes.Equal(got, make([]byte, len(data))) {
+		t.Errorf("thin device is not zeroed")
+	}
+}
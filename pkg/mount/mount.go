@@ -0,0 +1,179 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+	"k8s.io/klog/v2"
+)
+
+// mount 模块所需命令
+const (
+	mountCmd  string = "mount"
+	umountCmd string = "umount"
+	blkidCmd  string = "blkid"
+)
+
+// 支持的文件系统类型
+const (
+	FsTypeExt4 string = "ext4"
+	FsTypeXfs  string = "xfs"
+
+	DefaultFsType = FsTypeExt4
+)
+
+// blkid 在没有探测到任何签名时的退出码
+const blkidExitCodeNotFound = 2
+
+// IsSupportedFsType 检查文件系统类型是否支持
+func IsSupportedFsType(fsType string) bool {
+	switch fsType {
+	case FsTypeExt4, FsTypeXfs:
+		return true
+	}
+	return false
+}
+
+// GetDiskFormat 获取设备上已有的文件系统类型, 返回空字符串表示设备是空白的
+// blkid -p -s TYPE -s PTTYPE -o export /dev/lvmvg/test
+func GetDiskFormat(device string) (string, error) {
+	var exitErr exec.ExitError
+
+	exec := exec.New()
+	out, err := exec.Command(blkidCmd, "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", device).CombinedOutput()
+	if err != nil {
+		if errors.As(err, &exitErr) && exitErr.ExitStatus() == blkidExitCodeNotFound {
+			return "", nil
+		}
+		return "", fmt.Errorf("blkid %s failed: %v, output: %s", device, err, strings.TrimSpace(string(out)))
+	}
+
+	var fsType, ptType string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "TYPE":
+			fsType = value
+		case "PTTYPE":
+			ptType = value
+		}
+	}
+
+	// 有分区表但没有文件系统, 不能当成空白设备处理
+	if len(fsType) == 0 && len(ptType) > 0 {
+		return "unknown data, probably partitions", nil
+	}
+
+	return fsType, nil
+}
+
+// FormatDevice 在设备上创建文件系统
+func FormatDevice(device, fsType string) error {
+	var args []string
+	switch fsType {
+	case FsTypeExt4:
+		args = []string{"-F", "-m0", device}
+	case FsTypeXfs:
+		args = []string{device}
+	default:
+		return fmt.Errorf("unsupported fsType: %s", fsType)
+	}
+
+	mkfsCmd := "mkfs." + fsType
+	klog.Infof("formatting device %s with %s", device, fsType)
+
+	exec := exec.New()
+	out, err := exec.Command(mkfsCmd, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v, output: %s", mkfsCmd, device, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// Mount 执行 mount -t fsType -o options source target
+func Mount(source, target, fsType string, options []string) error {
+	var mountArg []string
+
+	if len(fsType) > 0 {
+		mountArg = append(mountArg, "-t", fsType)
+	}
+	if len(options) > 0 {
+		mountArg = append(mountArg, "-o", strings.Join(options, ","))
+	}
+	mountArg = append(mountArg, source, target)
+
+	klog.Infof("mounting %s to %s, fsType: %s, options: %v", source, target, fsType, options)
+
+	exec := exec.New()
+	out, err := exec.Command(mountCmd, mountArg...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mount %s to %s failed: %v, output: %s", source, target, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// Unmount 执行 umount target
+func Unmount(target string) error {
+	klog.Infof("unmounting %s", target)
+
+	exec := exec.New()
+	out, err := exec.Command(umountCmd, target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("umount %s failed: %v, output: %s", target, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// FormatAndMount 设备为空白时先格式化再挂载, 已有文件系统时要求与 fsType 一致
+func FormatAndMount(device, target, fsType string, options []string) error {
+	existingFormat, err := GetDiskFormat(device)
+	if err != nil {
+		return err
+	}
+
+	if len(existingFormat) == 0 {
+		if err := FormatDevice(device, fsType); err != nil {
+			return err
+		}
+	} else if existingFormat != fsType {
+		return fmt.Errorf("device %s already formatted with %s, but %s is requested", device, existingFormat, fsType)
+	}
+
+	return Mount(device, target, fsType, options)
+}
+
+// BindMount 将 source bind mount 到 target, 有额外挂载选项 (如 ro) 时再 remount 使其生效
+// mount --bind source target
+// mount -o remount,bind,ro target
+func BindMount(source, target string, options []string) error {
+	if err := Mount(source, target, "", []string{"bind"}); err != nil {
+		return err
+	}
+
+	if len(options) == 0 {
+		return nil
+	}
+
+	remountOptions := append([]string{"remount", "bind"}, options...)
+	klog.Infof("remounting %s with options: %v", target, remountOptions)
+
+	exec := exec.New()
+	out, err := exec.Command(mountCmd, "-o", strings.Join(remountOptions, ","), target).CombinedOutput()
+	if err != nil {
+		// remount 失败时不能留下一个读写的 bind mount
+		if umountErr := Unmount(target); umountErr != nil {
+			klog.Errorf("rollback bind mount %s failed: %v", target, umountErr)
+		}
+		return fmt.Errorf("remount %s failed: %v, output: %s", target, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 内核导出的当前进程挂载信息
+const procMountInfo = "/proc/self/mountinfo"
+
+// MountInfo 对应 /proc/self/mountinfo 中的一行
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+type MountInfo struct {
+	MountID      int
+	ParentID     int
+	Major        uint64
+	Minor        uint64
+	Root         string
+	MountPoint   string
+	MountOptions []string
+	FsType       string
+	Source       string
+	SuperOptions []string
+}
+
+// ListMountInfo 读取当前进程的挂载信息
+func ListMountInfo() ([]MountInfo, error) {
+	return ParseMountInfo(procMountInfo)
+}
+
+// ParseMountInfo 解析 mountinfo 格式的文件
+func ParseMountInfo(path string) ([]MountInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var infos []MountInfo
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+		info, err := parseMountInfoLine(line)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+func parseMountInfoLine(line string) (MountInfo, error) {
+	fields := strings.Fields(line)
+	// 至少包含 7 个固定字段, "-" 分隔符以及 fstype, source
+	if len(fields) < 10 {
+		return MountInfo{}, fmt.Errorf("wrong number of fields in mountinfo line: %q", line)
+	}
+
+	mountID, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return MountInfo{}, fmt.Errorf("parse mount id %q failed: %v", fields[0], err)
+	}
+	parentID, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return MountInfo{}, fmt.Errorf("parse parent id %q failed: %v", fields[1], err)
+	}
+	majorStr, minorStr, ok := strings.Cut(fields[2], ":")
+	if !ok {
+		return MountInfo{}, fmt.Errorf("parse major:minor %q failed", fields[2])
+	}
+	major, err := strconv.ParseUint(majorStr, 10, 64)
+	if err != nil {
+		return MountInfo{}, fmt.Errorf("parse major %q failed: %v", majorStr, err)
+	}
+	minor, err := strconv.ParseUint(minorStr, 10, 64)
+	if err != nil {
+		return MountInfo{}, fmt.Errorf("parse minor %q failed: %v", minorStr, err)
+	}
+
+	info := MountInfo{
+		MountID:      mountID,
+		ParentID:     parentID,
+		Major:        major,
+		Minor:        minor,
+		Root:         unescapeOctal(fields[3]),
+		MountPoint:   unescapeOctal(fields[4]),
+		MountOptions: strings.Split(fields[5], ","),
+	}
+
+	// 可选字段以单独的 "-" 结束
+	i := 6
+	for ; i < len(fields) && fields[i] != "-"; i++ {
+	}
+	if i+2 >= len(fields) {
+		return MountInfo{}, fmt.Errorf("missing separator in mountinfo line: %q", line)
+	}
+	info.FsType = fields[i+1]
+	info.Source = unescapeOctal(fields[i+2])
+	if i+3 < len(fields) {
+		info.SuperOptions = strings.Split(fields[i+3], ",")
+	}
+
+	return info, nil
+}
+
+// mountinfo 中的空格等字符会被转义为 \040 这样的八进制形式
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// GetMountsByMountPoint 返回挂载到 target 上的所有挂载项
+func GetMountsByMountPoint(target string) ([]MountInfo, error) {
+	target = filepath.Clean(target)
+
+	infos, err := ListMountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []MountInfo
+	for _, info := range infos {
+		if info.MountPoint == target {
+			mounts = append(mounts, info)
+		}
+	}
+	return mounts, nil
+}
+
+// IsMountPoint 检查 target 是否为挂载点
+func IsMountPoint(target string) (bool, error) {
+	mounts, err := GetMountsByMountPoint(target)
+	if err != nil {
+		return false, err
+	}
+	return len(mounts) > 0, nil
+}
+
+// GetMountsByDevice 返回 device 的所有挂载项, 包括 block 模式下 bind mount 设备文件的挂载项
+// device 不存在 (例如 lv 未激活) 时返回空
+func GetMountsByDevice(device string) ([]MountInfo, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(device, &st); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("stat %s failed: %v", device, err)
+	}
+
+	// /dev/lvmvg/pvc-xxx 为指向 /dev/dm-N 的软链接
+	devNode, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s failed: %v", device, err)
+	}
+
+	infos, err := ListMountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	major, minor := splitDevNumber(uint64(st.Rdev))
+	return filterMountsByDevice(infos, major, minor, devNode), nil
+}
+
+func filterMountsByDevice(infos []MountInfo, major, minor uint64, devNode string) []MountInfo {
+	var mounts []MountInfo
+	for _, info := range infos {
+		// 文件系统挂载的设备号为块设备的设备号, 设备文件的 bind mount 来自 devtmpfs
+		if (info.Major == major && info.Minor == minor) ||
+			(info.FsType == "devtmpfs" && filepath.Join("/dev", info.Root) == devNode) {
+			mounts = append(mounts, info)
+		}
+	}
+	return mounts
+}
+
+// 按照 linux 的 dev_t 编码拆分主次设备号
+func splitDevNumber(dev uint64) (uint64, uint64) {
+	major := (dev>>8)&0xfff | (dev>>32)&0xfffff000
+	minor := dev&0xff | (dev>>12)&0xffffff00
+	return major, minor
+}
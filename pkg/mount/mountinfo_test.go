@@ -0,0 +1,96 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testMountInfo = `22 28 0:20 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+28 1 253:1 / / rw,relatime shared:1 - ext4 /dev/mapper/root rw,errors=remount-ro
+410 28 253:3 / /var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount rw,relatime shared:200 - ext4 /dev/mapper/lvmvg-pvc--1 rw
+420 28 253:3 / /var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pvc-1/mount ro,relatime shared:200 - ext4 /dev/mapper/lvmvg-pvc--1 rw
+430 28 0:50 / /mnt/with\040space rw - tmpfs tmpfs rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	if err := os.WriteFile(path, []byte(testMountInfo), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := ParseMountInfo(path)
+	if err != nil {
+		t.Fatalf("parse mountinfo failed: %v", err)
+	}
+	if len(infos) != 5 {
+		t.Fatalf("expected 5 mounts, got %d", len(infos))
+	}
+
+	staging := infos[2]
+	if staging.Major != 253 || staging.Minor != 3 {
+		t.Errorf("unexpected major:minor %d:%d", staging.Major, staging.Minor)
+	}
+	if staging.FsType != "ext4" || staging.Source != "/dev/mapper/lvmvg-pvc--1" {
+		t.Errorf("unexpected fstype/source %s %s", staging.FsType, staging.Source)
+	}
+
+	publish := infos[3]
+	if publish.MountOptions[0] != "ro" {
+		t.Errorf("expected ro mount options, got %v", publish.MountOptions)
+	}
+
+	if infos[4].MountPoint != "/mnt/with space" {
+		t.Errorf("expected unescaped mount point, got %q", infos[4].MountPoint)
+	}
+}
+
+func TestParseMountInfoLineInvalid(t *testing.T) {
+	if _, err := parseMountInfoLine("22 28 0:20 / /sys rw"); err == nil {
+		t.Error("expected error for short line")
+	}
+	if _, err := parseMountInfoLine("22 28 0:20 / /sys rw shared:7 sysfs sysfs rw x"); err == nil {
+		t.Error("expected error for missing separator")
+	}
+}
+
+func TestFilterMountsByDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	// block 模式下设备文件的 bind mount 来自 devtmpfs
+	content := testMountInfo + `440 28 0:5 /dm-4 /var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pvc-2/uid rw,nosuid shared:2 - devtmpfs udev rw
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := ParseMountInfo(path)
+	if err != nil {
+		t.Fatalf("parse mountinfo failed: %v", err)
+	}
+
+	if mounts := filterMountsByDevice(infos, 253, 3, "/dev/dm-3"); len(mounts) != 2 {
+		t.Errorf("expected 2 mounts of 253:3, got %d", len(mounts))
+	}
+	if mounts := filterMountsByDevice(infos, 253, 4, "/dev/dm-4"); len(mounts) != 1 || mounts[0].FsType != "devtmpfs" {
+		t.Errorf("expected bind mount of /dev/dm-4, got %+v", mounts)
+	}
+	if mounts := filterMountsByDevice(infos, 253, 5, "/dev/dm-5"); len(mounts) != 0 {
+		t.Errorf("expected no mounts of 253:5, got %+v", mounts)
+	}
+}
+
+func TestSplitDevNumber(t *testing.T) {
+	cases := []struct {
+		dev          uint64
+		major, minor uint64
+	}{
+		{dev: 0xfd03, major: 253, minor: 3},
+		{dev: 0x801, major: 8, minor: 1},
+		// 次设备号大于 255 时高位编码在 bit 20 之后
+		{dev: 0x1000fd00, major: 253, minor: 0x10000},
+	}
+	for _, c := range cases {
+		if major, minor := splitDevNumber(c.dev); major != c.major || minor != c.minor {
+			t.Errorf("splitDevNumber(%#x) = %d:%d, expected %d:%d", c.dev, major, minor, c.major, c.minor)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package mount
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/caoyingjunz/pixiulib/exec"
+	"k8s.io/klog/v2"
+)
+
+// 在线扩容文件系统所需命令
+const (
+	resize2fsCmd string = "resize2fs"
+	xfsGrowfsCmd string = "xfs_growfs"
+)
+
+// ResizeFs 在挂载状态下将文件系统扩展到设备的大小
+// ext4: resize2fs /dev/lvmvg/pvc-xxx
+// xfs:  xfs_growfs /var/lib/kubelet/pods/.../mount
+func ResizeFs(device, mountPoint, fsType string) error {
+	var resizeCmd string
+	var resizeArg []string
+
+	switch fsType {
+	case FsTypeExt4:
+		resizeCmd, resizeArg = resize2fsCmd, []string{device}
+	case FsTypeXfs:
+		resizeCmd, resizeArg = xfsGrowfsCmd, []string{mountPoint}
+	default:
+		return fmt.Errorf("resize of fsType %s is not supported", fsType)
+	}
+
+	klog.Infof("resizing %s filesystem on %s, mount point: %s", fsType, device, mountPoint)
+
+	exec := exec.New()
+	out, err := exec.Command(resizeCmd, resizeArg...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v, output: %s", resizeCmd, strings.Join(resizeArg, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	klog.Info(string(out))
+	return nil
+}
+
+// ResizeToolsAvailable 是否安装了在线扩容文件系统所需的命令
+func ResizeToolsAvailable() bool {
+	exec := exec.New()
+	for _, cmd := range []string{resize2fsCmd, xfsGrowfsCmd} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			klog.Infof("%s not found: %v", cmd, err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package mount
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// FsStats 为文件系统的容量和 inode 使用情况
+type FsStats struct {
+	// 单位为 byte
+	TotalBytes     int64
+	AvailableBytes int64
+	UsedBytes      int64
+
+	TotalInodes int64
+	FreeInodes  int64
+	UsedInodes  int64
+}
+
+// GetFsStats 通过 statfs 获取 path 所在文件系统的使用情况
+func GetFsStats(path string) (*FsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, fmt.Errorf("statfs %s failed: %v", path, err)
+	}
+
+	bsize := int64(st.Bsize)
+	return &FsStats{
+		TotalBytes:     int64(st.Blocks) * bsize,
+		AvailableBytes: int64(st.Bavail) * bsize,
+		UsedBytes:      int64(st.Blocks-st.Bfree) * bsize,
+		TotalInodes:    int64(st.Files),
+		FreeInodes:     int64(st.Ffree),
+		UsedInodes:     int64(st.Files - st.Ffree),
+	}, nil
+}